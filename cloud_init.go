@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/textproto"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// cloudInitBoundary fixed so that the same parts always render to the same user data
+const cloudInitBoundary = "MIMEBOUNDARY"
+
+// CloudInitPart a single part of a multipart cloud-init user data message
+type CloudInitPart struct {
+	content_type string
+	filename     string
+	content      string
+	merge_type   string
+}
+
+// toCloudInitParts Convenience function to convert the cloud_init_part blocks of a resource to CloudInitParts
+func toCloudInitParts(d *schema.ResourceData) []CloudInitPart {
+	partList := d.Get("cloud_init_part").([]interface{})
+
+	parts := make([]CloudInitPart, 0, len(partList))
+	for _, v := range partList {
+		partMap := v.(map[string]interface{})
+		parts = append(parts, CloudInitPart{
+			content_type: partMap["content_type"].(string),
+			filename:     partMap["filename"].(string),
+			content:      partMap["content"].(string),
+			merge_type:   partMap["merge_type"].(string),
+		})
+	}
+	return parts
+}
+
+// renderCloudInitParts assemble the parts into a MIME multipart message understood by cloud-init,
+// optionally gzip compressing the result.
+func renderCloudInitParts(parts []CloudInitPart, gzipOutput bool) (string, error) {
+	log.Printf("[DEBUG] [renderCloudInitParts] Rendering %d cloud-init parts (gzip: %t)", len(parts), gzipOutput)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\n", cloudInitBoundary))
+	buffer.WriteString("MIME-Version: 1.0\n\n")
+
+	writer := multipart.NewWriter(&buffer)
+	if err := writer.SetBoundary(cloudInitBoundary); err != nil {
+		return "", err
+	}
+
+	for i, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.content_type)
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "7bit")
+		if part.filename != "" {
+			header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", part.filename))
+		}
+		if part.merge_type != "" {
+			header.Set("X-Merge-Type", part.merge_type)
+		}
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			log.Printf("[ERROR] [renderCloudInitParts] Unable to create part %d", i)
+			return "", err
+		}
+
+		if _, err := partWriter.Write([]byte(part.content)); err != nil {
+			log.Printf("[ERROR] [renderCloudInitParts] Unable to write part %d", i)
+			return "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	if !gzipOutput {
+		return buffer.String(), nil
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(buffer.Bytes()); err != nil {
+		return "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+	return compressed.String(), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var testCloudInitParts = []CloudInitPart{
+	{content_type: "text/cloud-config", filename: "common.yaml", content: "#cloud-config\npackages:\n  - ntp\n", merge_type: "list(append)+dict(recurse_array)+str()"},
+	{content_type: "text/x-shellscript", content: "#!/bin/bash\necho hello\n"},
+}
+
+func TestRenderCloudInitParts(t *testing.T) {
+	rendered, err := renderCloudInitParts(testCloudInitParts, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{
+		"Content-Type: multipart/mixed; boundary=\"MIMEBOUNDARY\"\nMIME-Version: 1.0\n\n",
+		"Content-Type: text/cloud-config\r\n",
+		"Content-Disposition: attachment; filename=\"common.yaml\"\r\n",
+		"X-Merge-Type: list(append)+dict(recurse_array)+str()\r\n",
+		"#cloud-config\npackages:\n  - ntp\n",
+		"Content-Type: text/x-shellscript\r\n",
+		"#!/bin/bash\necho hello\n",
+		"--MIMEBOUNDARY--",
+	}
+	for _, e := range expected {
+		if !strings.Contains(rendered, e) {
+			t.Fatalf("rendered user data does not contain %q:\n%s", e, rendered)
+		}
+	}
+
+	again, err := renderCloudInitParts(testCloudInitParts, false)
+	if err != nil || again != rendered {
+		t.Fatalf("rendering the same parts twice should give the same result")
+	}
+}
+
+func TestRenderCloudInitPartsGzip(t *testing.T) {
+	plain, err := renderCloudInitParts(testCloudInitParts, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	compressed, err := renderCloudInitParts(testCloudInitParts, true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewBufferString(compressed))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(decompressed) != plain {
+		t.Fatalf("decompressed user data does not match the plain rendering")
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"sync"
 
 	"github.com/juju/gomaasapi"
 )
@@ -21,125 +23,173 @@ type NodeInfo struct {
 	osystem       string
 	status        uint16
 	tag_names     []string
+	fqdn          string
+	ip_addresses  []string
+	boot_mac      string
+	interfaces    []NodeInterface
+	block_devices []NodeBlockDevice
+	zone          string
+	pool          string
+	owner         string
+	locked        bool
+	warnings      []string
 	data          map[string]interface{}
 }
 
-// toNodeInfo Convenience function to convert a MAASObject to NodeInfo
+// NodeSubnet the subnet a node's interface is linked to
+type NodeSubnet struct {
+	Name string `json:"name"`
+	CIDR string `json:"cidr"`
+}
+
+// NodeLink a link between a node's interface and a subnet
+type NodeLink struct {
+	Mode      string      `json:"mode"`
+	IPAddress string      `json:"ip_address"`
+	Subnet    *NodeSubnet `json:"subnet"`
+}
+
+// NodeVLAN the VLAN of a node's interface
+type NodeVLAN struct {
+	VID    int    `json:"vid"`
+	Name   string `json:"name"`
+	Fabric string `json:"fabric"`
+}
+
+// NodeInterface a network interface of a node
+type NodeInterface struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	MACAddress string     `json:"mac_address"`
+	VLAN       *NodeVLAN  `json:"vlan"`
+	Links      []NodeLink `json:"links"`
+}
+
+// NodeBlockDevice a physical block device of a node
+type NodeBlockDevice struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Path      string   `json:"path"`
+	IDPath    string   `json:"id_path"`
+	Model     string   `json:"model"`
+	Serial    string   `json:"serial"`
+	Size      uint64   `json:"size"`
+	BlockSize uint64   `json:"block_size"`
+	Tags      []string `json:"tags"`
+}
+
+// NodeZone the zone or pool a node belongs to
+type NodeZone struct {
+	Name string `json:"name"`
+}
+
+// toNodeInfo Convenience function to convert a MAASObject to NodeInfo.
+// Only the system_id is required; any other field that is absent or null is left empty, and a field
+// that can't be parsed is left empty with a warning recorded in NodeInfo.warnings.
 func toNodeInfo(nodeObject *gomaasapi.MAASObject) (*NodeInfo, error) {
 	log.Println("[DEBUG] [toNodeInfo] Attempting to convert node information from MAASObject to NodeInfo")
 
-	nodeMap := nodeObject.GetMap()
-
-	system_id, err := nodeMap["system_id"].GetString()
+	rawJSON, err := json.Marshal(nodeObject)
 	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get node (%s)\n", system_id)
+		log.Println("[ERROR] [toNodeInfo] Unable to convert node information to JSON")
 		return nil, err
 	}
 
-	hostname, err := nodeMap["hostname"].GetString()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the node (%s) hostname\n", system_id)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rawJSON, &fields); err != nil {
+		log.Println("[ERROR] [toNodeInfo] Unable to Unmarshal node JSON")
 		return nil, err
 	}
 
-	node_url := nodeObject.URL().String()
-	if len(node_url) == 0 {
-		return nil, errors.New("[ERROR] [toNodeInfo] Empty URL for node")
-	}
+	node := &NodeInfo{}
 
-	power_state, err := nodeMap["power_state"].GetString()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the power_state for node: %s\n", system_id)
-		return nil, err
+	if !node.decodeField(fields, "system_id", &node.system_id) || node.system_id == "" {
+		log.Println("[ERROR] [toNodeInfo] Unable to get the node system_id")
+		return nil, errors.New("[ERROR] [toNodeInfo] Node has no system_id")
 	}
 
-	cpu_count_float, err := nodeMap["cpu_count"].GetFloat64()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the cpu_count for node: %s\n", system_id)
-		log.Printf("[ERROR] [toNodeInfo] Error: %s\n", err)
-		log.Printf("[ERROR] [toNodeInfo] cpu_count_float: %v\n", cpu_count_float)
-		log.Println("[ERROR] [toNodeInfo] Defaulting cpu_count to 0")
-		cpu_count_float = 0
+	if node_url := nodeObject.URL(); node_url != nil {
+		node.url = node_url.String()
 	}
-	cpu_count := uint16(cpu_count_float)
-
-	architecture, err := nodeMap["architecture"].GetString()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the node (%s) architecture\n", system_id)
-		return nil, err
+	if len(node.url) == 0 {
+		node.warn("empty URL")
 	}
 
-	distro_series, err := nodeMap["distro_series"].GetString()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the distro_series for node: %s\n", system_id)
-		return nil, err
+	var cpu_count, memory, status float64
+	var boot_interface *NodeInterface
+	var zone, pool *NodeZone
+
+	node.decodeField(fields, "hostname", &node.hostname)
+	node.decodeField(fields, "fqdn", &node.fqdn)
+	node.decodeField(fields, "power_state", &node.power_state)
+	node.decodeField(fields, "cpu_count", &cpu_count)
+	node.decodeField(fields, "architecture", &node.architecture)
+	node.decodeField(fields, "distro_series", &node.distro_series)
+	node.decodeField(fields, "memory", &memory)
+	node.decodeField(fields, "osystem", &node.osystem)
+	node.decodeField(fields, "status", &status)
+	node.decodeField(fields, "tag_names", &node.tag_names)
+	node.decodeField(fields, "owner", &node.owner)
+	node.decodeField(fields, "locked", &node.locked)
+	node.decodeField(fields, "ip_addresses", &node.ip_addresses)
+	node.decodeField(fields, "boot_interface", &boot_interface)
+	node.decodeField(fields, "interface_set", &node.interfaces)
+	node.decodeField(fields, "physicalblockdevice_set", &node.block_devices)
+	node.decodeField(fields, "zone", &zone)
+	node.decodeField(fields, "pool", &pool)
+
+	node.cpu_count = uint16(cpu_count)
+	node.memory = uint64(memory)
+	node.status = uint16(status)
+	if node.tag_names == nil {
+		node.tag_names = []string{}
 	}
-
-	memory_float, err := nodeMap["memory"].GetFloat64()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the memory for node: %s\n", system_id)
-		log.Printf("[ERROR] [toNodeInfo] Error: %s\n", err)
-		log.Printf("[ERROR] [toNodeInfo] memory_float: %v\n", memory_float)
-		log.Print("[ERROR] [toNodeInfo] Defaulting memory to 0")
-		memory_float = 0
+	if boot_interface != nil {
+		node.boot_mac = boot_interface.MACAddress
 	}
-	memory := uint64(memory_float)
-
-	osystem, err := nodeMap["osystem"].GetString()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the OS for node: %s\n", system_id)
-		return nil, err
+	if zone != nil {
+		node.zone = zone.Name
 	}
-
-	status_float, err := nodeMap["status"].GetFloat64()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the status for node: %s\n", system_id)
-		return nil, err
+	if pool != nil {
+		node.pool = pool.Name
 	}
-	status := uint16(status_float)
 
-	tag_names, err := nodeMap["tag_names"].GetArray()
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to get the tags for node: %s\n", system_id)
+	if err := json.Unmarshal(rawJSON, &node.data); err != nil {
+		log.Printf("[ERROR] [toNodeInfo] Unable to Unmarshal JSON data for node: %s\n", node.system_id)
 		return nil, err
 	}
 
-	tag_array := make([]string, 0, 1)
-	for _, tag_object := range tag_names {
-		tag_name, err := tag_object.GetString()
-		if err != nil {
-			log.Printf("[ERROR] [toNodeInfo] Unable to parse tag information (%v) for node (%s)", tag_object, system_id)
-			return nil, err
-		}
-		tag_array = append(tag_array, tag_name)
+	for _, warning := range node.warnings {
+		log.Printf("[WARN] [toNodeInfo] Node (%s): %s", node.system_id, warning)
 	}
+	log.Printf("[DEBUG] [toNodeInfo] Node (%s) JSON:\n%s\n", node.system_id, rawJSON)
 
-	prettyJSON, err := json.MarshalIndent(nodeObject, "", "    ")
-	if err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to convert node (%s) information to JSON\n", system_id)
-		return nil, err
-	}
+	return node, nil
+}
 
-	log.Printf("[DEBUG] [toNodeInfo] Node (%s) JSON:\n%s\n", system_id, prettyJSON)
+// decodeField decode a single field of the node's JSON into target.
+// It returns false, leaving target untouched, when the field is absent, null or can't be parsed.
+func (n *NodeInfo) decodeField(fields map[string]json.RawMessage, name string, target interface{}) bool {
+	raw, ok := fields[name]
+	if !ok || string(raw) == "null" {
+		return false
+	}
 
-	var raw_data map[string]interface{}
-	if err := json.Unmarshal(prettyJSON, &raw_data); err != nil {
-		log.Printf("[ERROR] [toNodeInfo] Unable to Unmarshal JSON data for node: %s\n", system_id)
-		return nil, err
+	if err := json.Unmarshal(raw, target); err != nil {
+		n.warn(fmt.Sprintf("unable to parse %s: %s", name, err))
+		return false
 	}
+	return true
+}
+
+// warn record a problem found while parsing the node
+func (n *NodeInfo) warn(warning string) {
+	n.warnings = append(n.warnings, warning)
+}
 
-	return &NodeInfo{system_id: system_id,
-		hostname:      hostname,
-		url:           node_url,
-		power_state:   power_state,
-		cpu_count:     uint16(cpu_count),
-		architecture:  architecture,
-		distro_series: distro_series,
-		memory:        memory,
-		osystem:       osystem,
-		status:        uint16(status),
-		tag_names:     tag_array,
-		data:          raw_data}, nil
+// rawField the raw JSON of a field that NodeInfo doesn't model, or nil if the node doesn't have it
+func (n *NodeInfo) rawField(name string) interface{} {
+	return n.data[name]
 }
 
 // Config provider configuration
@@ -148,6 +198,10 @@ type Config struct {
 	APIURL     string
 	APIver     string
 	MAASObject *gomaasapi.MAASObject
+	sticky     StickyMachines
+
+	controllerLock sync.Mutex
+	controller     gomaasapi.Controller
 }
 
 // Client authenticate to MAAS and create a session
@@ -162,3 +216,27 @@ func (c *Config) Client() (interface{}, error) {
 	c.MAASObject = gomaasapi.NewMAAS(*authClient)
 	return c, nil
 }
+
+// Controller lazily create the higher level gomaasapi controller for the APIs MAASObject doesn't model.
+// Resources are refreshed in parallel, so the controller is created under a lock, and only a controller that was
+// created successfully is kept: a failure is tried again by the next caller.
+func (c *Config) Controller() (gomaasapi.Controller, error) {
+	c.controllerLock.Lock()
+	defer c.controllerLock.Unlock()
+
+	if c.controller != nil {
+		return c.controller, nil
+	}
+
+	log.Println("[DEBUG] [Config.Controller] Configuring the MAAS API controller")
+	controller, err := gomaasapi.NewController(gomaasapi.ControllerArgs{
+		BaseURL: gomaasapi.AddAPIVersionToURL(c.APIURL, c.APIver),
+		APIKey:  c.APIKey,
+	})
+	if err != nil {
+		log.Printf("[ERROR] [Config.Controller] Unable to create a controller for the MAAS Server (%s)", c.APIURL)
+		return nil, err
+	}
+	c.controller = controller
+	return c.controller, nil
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/juju/gomaasapi"
 )
 
 func TestNodeInfo(t *testing.T) {
@@ -17,3 +21,134 @@ func TestConfigStructure(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConfigControllerRetriesAfterFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := &Config{APIKey: "consumer:token:secret", APIURL: server.URL + "/MAAS/", APIver: "2.0"}
+	if _, err := config.Controller(); err == nil {
+		t.Fatal("expected the controller to fail")
+	}
+	failed := requests
+
+	if _, err := config.Controller(); err == nil {
+		t.Fatal("expected the controller to fail again")
+	}
+	if requests == failed {
+		t.Fatal("a failed controller should be created again, not cached")
+	}
+}
+
+const testNodeJSON = `{
+	"resource_uri": "/MAAS/api/2.0/machines/abc123/",
+	"system_id": "abc123",
+	"hostname": "node-1",
+	"fqdn": "node-1.maas",
+	"power_state": "on",
+	"cpu_count": 4,
+	"architecture": "amd64/generic",
+	"distro_series": "xenial",
+	"memory": 8192,
+	"osystem": "ubuntu",
+	"status": 6,
+	"tag_names": ["virtual"],
+	"owner": "admin",
+	"locked": true,
+	"ip_addresses": ["10.0.0.10"],
+	"zone": {"name": "zone-a", "description": ""},
+	"pool": {"name": "default"},
+	"boot_interface": {"id": 1, "name": "eth0", "mac_address": "52:54:00:00:00:01"},
+	"interface_set": [{
+		"id": 1,
+		"name": "eth0",
+		"mac_address": "52:54:00:00:00:01",
+		"vlan": {"vid": 0, "name": "untagged", "fabric": "fabric-0"},
+		"links": [{"mode": "auto", "ip_address": "10.0.0.10", "subnet": {"name": "10.0.0.0/24", "cidr": "10.0.0.0/24"}}]
+	}],
+	"physicalblockdevice_set": [{
+		"id": 3, "name": "sda", "path": "/dev/disk/by-dname/sda", "id_path": null,
+		"model": "QEMU HARDDISK", "serial": "QM00001", "size": 10000000000, "block_size": 512, "tags": ["rotary"]
+	}]
+}`
+
+func testNodeObject(t *testing.T, input string) gomaasapi.MAASObject {
+	client, err := gomaasapi.NewAnonymousClient("http://example.com/MAAS/", "2.0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj, err := gomaasapi.Parse(*client, []byte(input))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	nodeObject, err := obj.GetMAASObject()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return nodeObject
+}
+
+func TestToNodeInfoDetails(t *testing.T) {
+	nodeObject := testNodeObject(t, testNodeJSON)
+	node, err := toNodeInfo(&nodeObject)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if node.fqdn != "node-1.maas" || node.zone != "zone-a" || node.pool != "default" || node.owner != "admin" || !node.locked {
+		t.Fatalf("bad node: %+v", node)
+	}
+	if node.boot_mac != "52:54:00:00:00:01" || len(node.ip_addresses) != 1 || node.ip_addresses[0] != "10.0.0.10" {
+		t.Fatalf("bad node addresses: %+v", node)
+	}
+	if len(node.interfaces) != 1 || node.interfaces[0].VLAN.Fabric != "fabric-0" || node.interfaces[0].Links[0].Subnet.CIDR != "10.0.0.0/24" {
+		t.Fatalf("bad node interfaces: %+v", node.interfaces)
+	}
+	if len(node.block_devices) != 1 || node.block_devices[0].Size != 10000000000 || node.block_devices[0].Tags[0] != "rotary" {
+		t.Fatalf("bad node block devices: %+v", node.block_devices)
+	}
+}
+
+func TestToNodeInfoTolerant(t *testing.T) {
+	nodeObject := testNodeObject(t, `{
+		"resource_uri": "/MAAS/api/2.0/machines/def456/",
+		"system_id": "def456",
+		"hostname": "node-2",
+		"distro_series": null,
+		"osystem": null,
+		"cpu_count": "lots",
+		"status": 4,
+		"hardware_uuid": "0000-1111"
+	}`)
+
+	node, err := toNodeInfo(&nodeObject)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if node.system_id != "def456" || node.hostname != "node-2" || node.status != 4 {
+		t.Fatalf("bad node: %+v", node)
+	}
+	if node.distro_series != "" || node.osystem != "" || node.cpu_count != 0 || len(node.tag_names) != 0 {
+		t.Fatalf("absent and null fields should be empty: %+v", node)
+	}
+	if len(node.warnings) != 1 {
+		t.Fatalf("expected a single warning for cpu_count, got: %v", node.warnings)
+	}
+	if node.rawField("hardware_uuid") != "0000-1111" {
+		t.Fatalf("unmodeled fields should be available raw, got: %v", node.rawField("hardware_uuid"))
+	}
+}
+
+func TestToNodeInfoMissingSystemID(t *testing.T) {
+	nodeObject := testNodeObject(t, `{"resource_uri": "/MAAS/api/2.0/machines/ghi789/", "hostname": "node-3"}`)
+	if _, err := toNodeInfo(&nodeObject); err == nil {
+		t.Fatalf("expected an error for a node without a system_id")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/juju/gomaasapi"
+)
+
+// MAAS node status values of the rescue mode and testing actions
+const (
+	nodeStatusRescueMode         = 16
+	nodeStatusEnteringRescueMode = 17
+	nodeStatusExitingRescueMode  = 19
+	nodeStatusTesting            = 21
+	nodeStatusFailedTesting      = 22
+)
+
+// NodeTestResult the outcome of a single test script run on a node
+type NodeTestResult struct {
+	name   string
+	status string
+}
+
+// nodeWaitForStatus wait for a node to leave the pending status for one of the target statuses
+func nodeWaitForStatus(maas *gomaasapi.MAASObject, system_id string, pending int, targets ...int) error {
+	stateConf := &resource.StateChangeConf{
+		Pending:    []string{fmt.Sprintf("%d:", pending)},
+		Refresh:    getNodeStatus(maas, system_id),
+		Timeout:    30 * time.Minute,
+		Delay:      10 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+	for _, target := range targets {
+		stateConf.Target = append(stateConf.Target, fmt.Sprintf("%d:", target))
+	}
+
+	_, err := stateConf.WaitForState()
+	return err
+}
+
+// nodeSetRescueMode boot a deployed node into the rescue environment, or back into its deployed OS
+func nodeSetRescueMode(maas *gomaasapi.MAASObject, system_id string, rescue bool) error {
+	if rescue {
+		log.Printf("[DEBUG] [nodeSetRescueMode] Entering rescue mode on node (%s)", system_id)
+		if err := nodeDo(maas, system_id, "rescue_mode", url.Values{}); err != nil {
+			return err
+		}
+		if err := nodeWaitForStatus(maas, system_id, nodeStatusEnteringRescueMode, nodeStatusRescueMode); err != nil {
+			return fmt.Errorf("[ERROR] [nodeSetRescueMode] Error waiting for node (%s) to enter rescue mode: %s", system_id, err)
+		}
+		return nil
+	}
+
+	log.Printf("[DEBUG] [nodeSetRescueMode] Exiting rescue mode on node (%s)", system_id)
+	if err := nodeDo(maas, system_id, "exit_rescue_mode", url.Values{}); err != nil {
+		return err
+	}
+	if err := nodeWaitForStatus(maas, system_id, nodeStatusExitingRescueMode, nodeStatusDeployed); err != nil {
+		return fmt.Errorf("[ERROR] [nodeSetRescueMode] Error waiting for node (%s) to exit rescue mode: %s", system_id, err)
+	}
+	return nil
+}
+
+// maasNodeTestResults This is a *low level* function that returns the results of the latest test run on a node
+func maasNodeTestResults(maas *gomaasapi.MAASObject, system_id string) ([]NodeTestResult, error) {
+	log.Printf("[DEBUG] [maasNodeTestResults] Getting test results of node (%s)", system_id)
+
+	result, err := maas.GetSubObject("nodes").GetSubObject(system_id).GetSubObject("results").GetSubObject("current-testing").CallGet("", url.Values{})
+	if err != nil {
+		log.Printf("[ERROR] [maasNodeTestResults] Unable to get test results of node (%s)", system_id)
+		return nil, err
+	}
+	return toNodeTestResults(result)
+}
+
+// toNodeTestResults Convenience function to convert a MAAS script set into its test results
+func toNodeTestResults(scriptSet gomaasapi.JSONObject) ([]NodeTestResult, error) {
+	scriptSetMap, err := scriptSet.GetMap()
+	if err != nil {
+		return nil, err
+	}
+
+	scripts, err := scriptSetMap["results"].GetArray()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]NodeTestResult, 0, len(scripts))
+	for _, script := range scripts {
+		scriptMap, err := script.GetMap()
+		if err != nil {
+			return nil, err
+		}
+		name, err := scriptMap["name"].GetString()
+		if err != nil {
+			return nil, err
+		}
+		status, err := scriptMap["status_name"].GetString()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, NodeTestResult{name: name, status: status})
+	}
+	return results, nil
+}
+
+// nodeRunTests run test scripts on a deployed node and wait for them to finish.
+// The results are returned along with an error naming the tests that didn't pass.
+func nodeRunTests(maas *gomaasapi.MAASObject, system_id string, scripts []string) ([]NodeTestResult, error) {
+	log.Printf("[DEBUG] [nodeRunTests] Running tests %v on node (%s)", scripts, system_id)
+
+	params := url.Values{}
+	params.Set("testing_scripts", strings.Join(scripts, ","))
+	if err := nodeDo(maas, system_id, "test", params); err != nil {
+		return nil, err
+	}
+
+	if err := nodeWaitForStatus(maas, system_id, nodeStatusTesting, nodeStatusDeployed, nodeStatusFailedTesting); err != nil {
+		return nil, fmt.Errorf("[ERROR] [nodeRunTests] Error waiting for tests to finish on node (%s): %s", system_id, err)
+	}
+
+	results, err := maasNodeTestResults(maas, system_id)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	for _, result := range results {
+		if result.status != "Passed" && result.status != "Skipped" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", result.name, result.status))
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("Tests failed on node (%s): %s", system_id, strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// flattenNodeTestResults Convenience function to convert test results to the test_results attribute
+func flattenNodeTestResults(results []NodeTestResult) []map[string]interface{} {
+	flattened := make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		flattened = append(flattened, map[string]interface{}{
+			"name":   result.name,
+			"status": result.status,
+		})
+	}
+	return flattened
+}
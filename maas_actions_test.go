@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/juju/gomaasapi"
+)
+
+func TestToNodeTestResults(t *testing.T) {
+	client, err := gomaasapi.NewAnonymousClient("http://example.com/MAAS/", "2.0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	scriptSet, err := gomaasapi.Parse(*client, []byte(`{
+		"id": 12,
+		"result_type": 2,
+		"results": [
+			{"name": "smartctl-validate", "status_name": "Passed"},
+			{"name": "memtester", "status_name": "Failed"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	results, err := toNodeTestResults(scriptSet)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(results) != 2 || results[0].name != "smartctl-validate" || results[0].status != "Passed" || results[1].status != "Failed" {
+		t.Fatalf("bad results: %+v", results)
+	}
+}
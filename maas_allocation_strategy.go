@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sort"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// allocation_strategy values
+const (
+	allocationMAASDefault = "maas_default"
+	allocationSmallestFit = "smallest_fit"
+	allocationLargestFit  = "largest_fit"
+	allocationPreferTags  = "prefer_tags"
+)
+
+var allocationStrategies = []string{allocationMAASDefault, allocationSmallestFit, allocationLargestFit, allocationPreferTags}
+
+// AllocationFilter the constraints of a maas_instance, checked client-side against the candidate nodes
+type AllocationFilter struct {
+	hostname     string
+	architecture string
+	cpu_count    int
+	memory       int
+	tags         []string
+}
+
+// toAllocationFilter Convenience function to build the client-side filter of a resource
+func toAllocationFilter(d *schema.ResourceData) AllocationFilter {
+	filter := AllocationFilter{
+		hostname:     d.Get("hostname").(string),
+		architecture: d.Get("architecture").(string),
+		cpu_count:    d.Get("cpu_count").(int),
+		memory:       d.Get("memory").(int),
+	}
+	for _, tag := range d.Get("tags").(*schema.Set).List() {
+		filter.tags = append(filter.tags, tag.(string))
+	}
+	return filter
+}
+
+// matches whether a node is free to allocate and satisfies every constraint
+func (f AllocationFilter) matches(node NodeInfo) bool {
+	if node.status != nodeStatusReady {
+		return false
+	}
+	if f.hostname != "" && node.hostname != f.hostname {
+		return false
+	}
+	if f.architecture != "" && node.architecture != f.architecture && !suppressArchitectureDiff("", node.architecture, f.architecture, nil) {
+		return false
+	}
+	if int(node.cpu_count) < f.cpu_count || int(node.memory) < f.memory {
+		return false
+	}
+	for _, tag := range f.tags {
+		if !nodeHasTag(node, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeHasTag whether a node carries the given tag
+func nodeHasTag(node NodeInfo, tag string) bool {
+	for _, t := range node.tag_names {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeStorageSize the total size in bytes of a node's physical block devices
+func nodeStorageSize(node NodeInfo) uint64 {
+	size := uint64(0)
+	for _, device := range node.block_devices {
+		size += device.Size
+	}
+	return size
+}
+
+// nodeSmaller order nodes by CPU count, then memory, then storage
+func nodeSmaller(a NodeInfo, b NodeInfo) bool {
+	if a.cpu_count != b.cpu_count {
+		return a.cpu_count < b.cpu_count
+	}
+	if a.memory != b.memory {
+		return a.memory < b.memory
+	}
+	return nodeStorageSize(a) < nodeStorageSize(b)
+}
+
+// rankAllocationCandidates filter the nodes down to the ones that can be allocated and order them best first.
+// prefer_tags puts the nodes carrying the most preferred tags first and falls back to the smallest fit.
+func rankAllocationCandidates(nodes []NodeInfo, filter AllocationFilter, strategy string, preferred_tags []string) []NodeInfo {
+	candidates := make([]NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		if filter.matches(node) {
+			candidates = append(candidates, node)
+		}
+	}
+
+	preferred := func(node NodeInfo) int {
+		count := 0
+		for _, tag := range preferred_tags {
+			if nodeHasTag(node, tag) {
+				count++
+			}
+		}
+		return count
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch strategy {
+		case allocationLargestFit:
+			return nodeSmaller(b, a)
+		case allocationPreferTags:
+			if pa, pb := preferred(a), preferred(b); pa != pb {
+				return pa > pb
+			}
+		}
+		return nodeSmaller(a, b)
+	})
+	return candidates
+}
+
+// resourceMAASInstanceAllocate allocate a node for a resource according to its allocation_strategy.
+// The strategies other than maas_default pick the best candidate client-side and allocate it by system_id,
+// moving on to the next candidate if another user got there first, and finally leaving the choice to MAAS.
+func resourceMAASInstanceAllocate(d *schema.ResourceData, meta interface{}, constraints url.Values) (*NodeInfo, error) {
+	maas := meta.(*Config).MAASObject
+
+	strategy := d.Get("allocation_strategy").(string)
+	if strategy == allocationMAASDefault || constraints.Get("system_id") != "" {
+		return nodesAllocate(maas, constraints)
+	}
+
+	nodes, err := getAllNodes(maas)
+	if err != nil {
+		log.Println("[ERROR] [resourceMAASInstanceAllocate] Unable to list the allocation candidates")
+		return nil, err
+	}
+
+	preferred_tags := []string{}
+	for _, tag := range d.Get("preferred_tags").([]interface{}) {
+		preferred_tags = append(preferred_tags, tag.(string))
+	}
+
+	candidates := rankAllocationCandidates(nodes, toAllocationFilter(d), strategy, preferred_tags)
+	log.Printf("[DEBUG] [resourceMAASInstanceAllocate] %d of %d nodes are %s candidates", len(candidates), len(nodes), strategy)
+
+	for _, candidate := range candidates {
+		params := url.Values{}
+		params.Set("system_id", candidate.system_id)
+
+		nodeObj, err := nodesAllocate(maas, params)
+		if err == nil {
+			return nodeObj, nil
+		}
+		if !isConflictError(err) {
+			return nil, err
+		}
+		log.Printf("[DEBUG] [resourceMAASInstanceAllocate] Candidate (%s) was taken, trying the next one", candidate.system_id)
+	}
+
+	log.Println("[DEBUG] [resourceMAASInstanceAllocate] No candidate left, letting MAAS pick a node")
+	return nodesAllocate(maas, constraints)
+}
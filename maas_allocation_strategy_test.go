@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func testAllocationNodes() []NodeInfo {
+	return []NodeInfo{
+		{system_id: "big", status: nodeStatusReady, architecture: "amd64/generic", cpu_count: 128, memory: 1048576, tag_names: []string{"compute"}},
+		{system_id: "small", status: nodeStatusReady, architecture: "amd64/generic", cpu_count: 4, memory: 8192, tag_names: []string{"compute"}},
+		{system_id: "medium", status: nodeStatusReady, architecture: "amd64/generic", cpu_count: 8, memory: 16384, tag_names: []string{"compute", "ssd"}},
+		{system_id: "tiny", status: nodeStatusReady, architecture: "amd64/generic", cpu_count: 2, memory: 4096, tag_names: []string{"compute"}},
+		{system_id: "deployed", status: nodeStatusDeployed, architecture: "amd64/generic", cpu_count: 4, memory: 8192, tag_names: []string{"compute"}},
+		{system_id: "arm", status: nodeStatusReady, architecture: "arm64/generic", cpu_count: 4, memory: 8192, tag_names: []string{"compute"}},
+	}
+}
+
+func testCandidateIDs(candidates []NodeInfo) []string {
+	ids := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.system_id)
+	}
+	return ids
+}
+
+func TestRankAllocationCandidates(t *testing.T) {
+	filter := AllocationFilter{architecture: "amd64", cpu_count: 4, tags: []string{"compute"}}
+
+	cases := map[string][]string{
+		allocationSmallestFit: {"small", "medium", "big"},
+		allocationLargestFit:  {"big", "medium", "small"},
+		allocationPreferTags:  {"medium", "small", "big"},
+	}
+	for strategy, expected := range cases {
+		got := testCandidateIDs(rankAllocationCandidates(testAllocationNodes(), filter, strategy, []string{"ssd"}))
+		if len(got) != len(expected) {
+			t.Fatalf("%s: got %v, expected %v", strategy, got, expected)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("%s: got %v, expected %v", strategy, got, expected)
+			}
+		}
+	}
+}
+
+func TestRankAllocationCandidatesStorage(t *testing.T) {
+	nodes := []NodeInfo{
+		{system_id: "large-disk", status: nodeStatusReady, cpu_count: 4, memory: 8192, block_devices: []NodeBlockDevice{{Size: 4000000000000}}},
+		{system_id: "small-disk", status: nodeStatusReady, cpu_count: 4, memory: 8192, block_devices: []NodeBlockDevice{{Size: 500000000000}}},
+	}
+
+	got := testCandidateIDs(rankAllocationCandidates(nodes, AllocationFilter{}, allocationSmallestFit, nil))
+	if got[0] != "small-disk" {
+		t.Fatalf("expected the node with less storage first, got %v", got)
+	}
+}
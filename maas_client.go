@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"log"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -56,6 +57,71 @@ func maasAllocateNodes(maas *gomaasapi.MAASObject, params url.Values) (gomaasapi
 	return nodeObject.GetMAASObject()
 }
 
+// maasWhoAmI This is a *low level* function that returns the username of the MAAS user the API key belongs to
+func maasWhoAmI(maas *gomaasapi.MAASObject) (string, error) {
+	log.Println("[DEBUG] [maasWhoAmI] Getting the current MAAS user")
+
+	result, err := maas.GetSubObject("users").CallGet("whoami", url.Values{})
+	if err != nil {
+		log.Println("[ERROR] [maasWhoAmI] Unable to get the current MAAS user")
+		return "", err
+	}
+
+	resultMap, err := result.GetMap()
+	if err != nil {
+		return "", err
+	}
+	return resultMap["username"].GetString()
+}
+
+// maasListNames This is a *low level* function that returns the names of the objects in a MAAS collection,
+// such as zones or resourcepools
+func maasListNames(maas *gomaasapi.MAASObject, collection string) ([]string, error) {
+	log.Printf("[DEBUG] [maasListNames] Fetching list of %s", collection)
+
+	result, err := maas.GetSubObject(collection).CallGet("", url.Values{})
+	if err != nil {
+		log.Printf("[ERROR] [maasListNames] Unable to get list of %s", collection)
+		return nil, err
+	}
+
+	objects, err := result.GetArray()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		objectMap, err := object.GetMap()
+		if err != nil {
+			return nil, err
+		}
+		name, err := objectMap["name"].GetString()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// isNotFoundError Convenience function to check whether an API call failed because the object doesn't exist
+func isNotFoundError(err error) bool {
+	if serverErr, ok := gomaasapi.GetServerError(err); ok {
+		return serverErr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// isConflictError Convenience function to check whether an API call failed because of a conflict,
+// such as no machine being available to allocate
+func isConflictError(err error) bool {
+	if serverErr, ok := gomaasapi.GetServerError(err); ok {
+		return serverErr.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
 // maasReleaseNode Releases an aquired node back as a node in the ready state
 func maasReleaseNode(maas *gomaasapi.MAASObject, system_id string, params url.Values) error {
 	log.Printf("[DEBUG] [maasReleaseNode] Releasing node: %s", system_id)
@@ -68,6 +134,18 @@ func maasReleaseNode(maas *gomaasapi.MAASObject, system_id string, params url.Va
 	return nil
 }
 
+// MAAS node status values
+const (
+	nodeStatusReady            = 4
+	nodeStatusDeployed         = 6
+	nodeStatusBroken           = 8
+	nodeStatusDeploying        = 9
+	nodeStatusAllocated        = 10
+	nodeStatusFailedDeployment = 11
+	nodeStatusReleasing        = 12
+	nodeStatusDiskErasing      = 14
+)
+
 // getNodeStatus Convenience function used by resourceMAASInstanceCreate as a refresh function
 // to determine the current status of a particular MAAS managed node.
 // The function takes a fully intitialized MAASObject and a system_id.
@@ -92,6 +170,37 @@ func getNodeStatus(maas *gomaasapi.MAASObject, system_id string) resource.StateR
 	}
 }
 
+// maasQueryPowerState This is a *low level* function that asks MAAS to query a node's BMC for its current power state.
+func maasQueryPowerState(maas *gomaasapi.MAASObject, system_id string) (string, error) {
+	log.Printf("[DEBUG] [maasQueryPowerState] Querying power state of node: %s", system_id)
+
+	result, err := maas.GetSubObject("machines").GetSubObject(system_id).CallGet("query_power_state", url.Values{})
+	if err != nil {
+		log.Printf("[ERROR] [maasQueryPowerState] Unable to query power state of node (%s)", system_id)
+		return "", err
+	}
+
+	resultMap, err := result.GetMap()
+	if err != nil {
+		return "", err
+	}
+	return resultMap["state"].GetString()
+}
+
+// getNodePowerState Convenience function used as a refresh function to determine the power state
+// reported by a node's BMC. It returns the state both as the result and as the status string.
+func getNodePowerState(maas *gomaasapi.MAASObject, system_id string) resource.StateRefreshFunc {
+	log.Printf("[DEBUG] [getNodePowerState] Getting power state of node: %s", system_id)
+	return func() (interface{}, string, error) {
+		state, err := maasQueryPowerState(maas, system_id)
+		if err != nil {
+			log.Printf("[ERROR] [getNodePowerState] Unable to get power state of node: %s\n", system_id)
+			return nil, "", err
+		}
+		return state, state, nil
+	}
+}
+
 // getSingleNode Convenience function to get a NodeInfo object for a single MAAS node.
 // The function takes a fully initialized MAASObject and returns a NodeInfo, error
 func getSingleNode(maas *gomaasapi.MAASObject, system_id string) (*NodeInfo, error) {
@@ -209,20 +318,21 @@ func parseConstraints(d *schema.ResourceData) (url.Values, error) {
 
 	cpu_count, set := d.GetOk("cpu_count")
 	if set {
-		retVal["cpu_count"] = strings.Fields(cpu_count.(string))
+		retVal.Set("cpu_count", strconv.Itoa(cpu_count.(int)))
 	}
 
+	// MAAS takes the memory constraint in MB as mem
 	memory, set := d.GetOk("memory")
 	if set {
-		retVal["memory"] = strings.Fields(memory.(string))
+		retVal.Set("mem", strconv.Itoa(memory.(int)))
 	}
 
 	tags, set := d.GetOk("tags")
 	if set {
-		tag_strings := make([]string, len(tags.([]interface{})))
+		tag_strings := make([]string, 0, tags.(*schema.Set).Len())
 
-		for i := range tags.([]interface{}) {
-			tag_strings[i] = tags.([]interface{})[i].(string)
+		for _, tag := range tags.(*schema.Set).List() {
+			tag_strings = append(tag_strings, tag.(string))
 		}
 		retVal["tags"] = tag_strings
 	}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// allocationListConstraints the allocate constraints that are passed to MAAS as a list of values
+var allocationListConstraints = map[string]string{
+	"tags":        "tags",
+	"not_tags":    "not_tags",
+	"not_in_zone": "not_in_zone",
+	"not_in_pool": "not_in_pool",
+	"subnets":     "subnets",
+	"not_subnets": "not_subnets",
+	"fabrics":     "fabrics",
+	"not_fabrics": "not_fabrics",
+}
+
+// allocationStringConstraints the allocate constraints that are passed to MAAS as a single value
+var allocationStringConstraints = map[string]string{
+	"hostname":     "name",
+	"architecture": "arch",
+	"zone":         "zone",
+	"pool":         "pool",
+	"pod":          "pod",
+	"not_pod":      "not_pod",
+	"interfaces":   "interfaces",
+	"storage":      "storage",
+	"agent_name":   "agent_name",
+}
+
+// allocationIntConstraints the allocate constraints that are passed to MAAS as a number
+var allocationIntConstraints = map[string]string{
+	"cpu_count": "cpu_count",
+	"memory":    "mem",
+}
+
+// allocationConstraintsSchema the schema of the full set of MAAS allocate constraints.
+// hostname, architecture, zone and pool are also filled in from the allocated machine.
+func allocationConstraintsSchema() map[string]*schema.Schema {
+	constraints := map[string]*schema.Schema{}
+
+	for name := range allocationListConstraints {
+		constraints[name] = &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			ForceNew: true,
+			Elem:     &schema.Schema{Type: schema.TypeString},
+		}
+	}
+
+	for name := range allocationStringConstraints {
+		constraints[name] = &schema.Schema{
+			Type:     schema.TypeString,
+			Optional: true,
+			ForceNew: true,
+		}
+	}
+
+	for name := range allocationIntConstraints {
+		constraints[name] = &schema.Schema{
+			Type:     schema.TypeInt,
+			Optional: true,
+			ForceNew: true,
+		}
+	}
+
+	for _, name := range []string{"hostname", "zone", "pool"} {
+		constraints[name].Computed = true
+	}
+	constraints["architecture"].Computed = true
+	constraints["architecture"].DiffSuppressFunc = suppressArchitectureDiff
+
+	return constraints
+}
+
+// parseAllocationConstraints parse the full set of allocate constraints into a url.Values that is passed to the API
+func parseAllocationConstraints(d *schema.ResourceData) url.Values {
+	log.Println("[DEBUG] [parseAllocationConstraints] Parsing MAAS allocate constraints")
+	retVal := url.Values{}
+
+	for name, param := range allocationListConstraints {
+		if v, ok := d.GetOk(name); ok {
+			for _, value := range v.([]interface{}) {
+				retVal.Add(param, value.(string))
+			}
+		}
+	}
+
+	for name, param := range allocationStringConstraints {
+		if v, ok := d.GetOk(name); ok {
+			retVal.Set(param, v.(string))
+		}
+	}
+
+	for name, param := range allocationIntConstraints {
+		if v, ok := d.GetOk(name); ok {
+			retVal.Set(param, strconv.Itoa(v.(int)))
+		}
+	}
+
+	log.Printf("[DEBUG] [parseAllocationConstraints] Constraints: %+v", retVal)
+	return retVal
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestParseAllocationConstraints(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceMAASMachineAllocation().Schema, map[string]interface{}{
+		"hostname":    "node-1",
+		"cpu_count":   8,
+		"memory":      16384,
+		"tags":        []interface{}{"ssd", "10g"},
+		"not_in_zone": []interface{}{"zone-b"},
+		"pool":        "compute",
+		"interfaces":  "eth0:space=storage",
+	})
+
+	params := parseAllocationConstraints(d)
+
+	expected := map[string]string{
+		"name":        "node-1",
+		"cpu_count":   "8",
+		"mem":         "16384",
+		"not_in_zone": "zone-b",
+		"pool":        "compute",
+		"interfaces":  "eth0:space=storage",
+	}
+	for k, v := range expected {
+		if params.Get(k) != v {
+			t.Fatalf("%s: got %q, expected %q (%+v)", k, params.Get(k), v, params)
+		}
+	}
+
+	if tags := params["tags"]; len(tags) != 2 || tags[0] != "ssd" || tags[1] != "10g" {
+		t.Fatalf("bad tags: %v", tags)
+	}
+	if _, ok := params["arch"]; ok {
+		t.Fatalf("unset constraints should not be passed: %+v", params)
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceMAASInstance().Schema, map[string]interface{}{
+		"cpu_count": 8,
+		"memory":    16384,
+		"tags":      []interface{}{"ssd"},
+	})
+
+	params, err := parseConstraints(d)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if params.Get("cpu_count") != "8" || params.Get("mem") != "16384" || params.Get("tags") != "ssd" {
+		t.Fatalf("bad constraints: %+v", params)
+	}
+	if _, ok := params["memory"]; ok {
+		t.Fatalf("memory should be passed as mem: %+v", params)
+	}
+}
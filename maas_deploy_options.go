@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/juju/gomaasapi"
+)
+
+// deployOptionVersions the deploy options passed straight to MAAS and the first MAAS version that supports each
+var deployOptionVersions = map[string]string{
+	"install_kvm":      "2.5",
+	"register_vmhost":  "3.0",
+	"ephemeral_deploy": "3.1",
+	"enable_hw_sync":   "3.2",
+}
+
+// maasGetVersion This is a *low level* function that returns the version of the MAAS server, ie: 2.9.2
+func maasGetVersion(maas *gomaasapi.MAASObject) (string, error) {
+	log.Println("[DEBUG] [maasGetVersion] Getting the MAAS server version")
+
+	result, err := maas.GetSubObject("version").CallGet("", url.Values{})
+	if err != nil {
+		log.Println("[ERROR] [maasGetVersion] Unable to get the MAAS server version")
+		return "", err
+	}
+
+	resultMap, err := result.GetMap()
+	if err != nil {
+		return "", err
+	}
+	return resultMap["version"].GetString()
+}
+
+// versionAtLeast compare the leading numeric parts of a MAAS version such as 2.9.2~rc1 against a minimum such as 2.9
+func versionAtLeast(version string, minimum string) bool {
+	parse := func(v string) []int {
+		parts := []int{}
+		for _, field := range strings.Split(v, ".") {
+			digits := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+			if digits == 0 {
+				break
+			}
+			if digits > 0 {
+				field = field[:digits]
+			}
+			n, _ := strconv.Atoi(field)
+			parts = append(parts, n)
+			if digits > 0 {
+				break
+			}
+		}
+		return parts
+	}
+
+	have, want := parse(version), parse(minimum)
+	for i, w := range want {
+		h := 0
+		if i < len(have) {
+			h = have[i]
+		}
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// resourceMAASInstanceDeployOptions build the deploy parameters for the deploy options that are set,
+// failing if the MAAS server is too old to support one of them
+func resourceMAASInstanceDeployOptions(d *schema.ResourceData, meta interface{}) (url.Values, error) {
+	params := url.Values{}
+	for option := range deployOptionVersions {
+		if d.Get(option).(bool) {
+			params.Set(option, strconv.FormatBool(true))
+		}
+	}
+	if len(params) == 0 {
+		return params, nil
+	}
+
+	version, err := maasGetVersion(meta.(*Config).MAASObject)
+	if err != nil {
+		return nil, err
+	}
+
+	for option := range params {
+		if !versionAtLeast(version, deployOptionVersions[option]) {
+			return nil, fmt.Errorf("%s needs MAAS %s or later, the server runs %s", option, deployOptionVersions[option], version)
+		}
+	}
+	return params, nil
+}
+
+// maasFindVMHost This is a *low level* function that returns the ID of the VM host running on a node, or 0 if there is none
+func maasFindVMHost(maas *gomaasapi.MAASObject, system_id string) (int, error) {
+	log.Printf("[DEBUG] [maasFindVMHost] Looking for the VM host on node (%s)", system_id)
+
+	result, err := maas.GetSubObject("pods").CallGet("", url.Values{})
+	if err != nil {
+		log.Println("[ERROR] [maasFindVMHost] Unable to get list of VM hosts")
+		return 0, err
+	}
+
+	pods, err := result.GetArray()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, pod := range pods {
+		podMap, err := pod.GetMap()
+		if err != nil {
+			return 0, err
+		}
+		hostMap, err := podMap["host"].GetMap()
+		if err != nil {
+			continue
+		}
+		host_id, err := hostMap["system_id"].GetString()
+		if err != nil || host_id != system_id {
+			continue
+		}
+		id, err := podMap["id"].GetFloat64()
+		if err != nil {
+			return 0, err
+		}
+		return int(id), nil
+	}
+	return 0, nil
+}
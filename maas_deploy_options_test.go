@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version  string
+		minimum  string
+		expected bool
+	}{
+		{"2.9.2", "2.5", true},
+		{"2.5", "2.5", true},
+		{"2.4.3", "2.5", false},
+		{"3.0.0~rc1", "3.0", true},
+		{"3.1.0", "3.2", false},
+		{"10.0", "3.2", true},
+		{"2.10.1", "2.9", true},
+	}
+
+	for _, c := range cases {
+		if got := versionAtLeast(c.version, c.minimum); got != c.expected {
+			t.Fatalf("versionAtLeast(%q, %q): got %v, expected %v", c.version, c.minimum, got, c.expected)
+		}
+	}
+}
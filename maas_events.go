@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/juju/gomaasapi"
+)
+
+// NodeEvent a single entry from a node's MAAS event log
+type NodeEvent struct {
+	id          int
+	level       string
+	event_type  string
+	description string
+}
+
+// cloud-init progress as reported through the MAAS event log
+const (
+	cloudInitRunning  = "running"
+	cloudInitFinished = "finished"
+	cloudInitFailed   = "failed"
+)
+
+// maasQueryNodeEvents This is a *low level* function that returns the events MAAS recorded for a node
+// with an id greater than after, oldest first.
+func maasQueryNodeEvents(maas *gomaasapi.MAASObject, system_id string, after int) ([]NodeEvent, error) {
+	log.Printf("[DEBUG] [maasQueryNodeEvents] Fetching events for node (%s) after event %d", system_id, after)
+
+	params := url.Values{}
+	params.Set("id", system_id)
+	params.Set("level", "DEBUG")
+	params.Set("limit", "1000")
+	if after > 0 {
+		params.Set("after", strconv.Itoa(after))
+	}
+
+	result, err := maas.GetSubObject("events").CallGet("query", params)
+	if err != nil {
+		log.Printf("[ERROR] [maasQueryNodeEvents] Unable to get events for node (%s)", system_id)
+		return nil, err
+	}
+
+	return toNodeEvents(result)
+}
+
+// toNodeEvents Convenience function to convert the result of an events query into NodeEvents, oldest first
+func toNodeEvents(result gomaasapi.JSONObject) ([]NodeEvent, error) {
+	resultMap, err := result.GetMap()
+	if err != nil {
+		return nil, err
+	}
+
+	eventObjects, err := resultMap["events"].GetArray()
+	if err != nil {
+		log.Println("[ERROR] [toNodeEvents] Unable to get the event array")
+		return nil, err
+	}
+
+	// MAAS returns the newest events first
+	events := make([]NodeEvent, len(eventObjects))
+	for i, eventObject := range eventObjects {
+		eventMap, err := eventObject.GetMap()
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := eventMap["id"].GetFloat64()
+		if err != nil {
+			return nil, err
+		}
+
+		event := NodeEvent{id: int(id)}
+		event.level, _ = eventMap["level"].GetString()
+		event.event_type, _ = eventMap["type"].GetString()
+		event.description, _ = eventMap["description"].GetString()
+
+		events[len(eventObjects)-1-i] = event
+	}
+	return events, nil
+}
+
+// latestNodeEventID return the id of the newest event MAAS recorded for a node, or 0 if there is none
+func latestNodeEventID(maas *gomaasapi.MAASObject, system_id string) (int, error) {
+	events, err := maasQueryNodeEvents(maas, system_id, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	latest := 0
+	for _, event := range events {
+		if event.id > latest {
+			latest = event.id
+		}
+	}
+	return latest, nil
+}
+
+// MAAS event types and descriptions the cloud-init wait relies on.
+// Messages reported back by the node are logged as "'<origin>' <description>", so the origin is the quoted prefix,
+// and cloud-init reports the start and the finish of its final stage with the same description.
+const (
+	eventTypeNodeChangedStatus  = "Node changed status"
+	eventTypeInstallFailure     = "Node installation failure"
+	eventStatusDeployed         = "to 'Deployed'"
+	eventOriginCloudInit        = "cloudinit"
+	cloudInitFinalStageDescribe = "running modules for final"
+)
+
+// eventOrigin the origin of a message the node reported back to MAAS, ie: cloudinit or curtin, or "" for MAAS' own events
+func eventOrigin(event NodeEvent) string {
+	if !strings.HasPrefix(event.description, "'") {
+		return ""
+	}
+	end := strings.Index(event.description[1:], "'")
+	if end < 0 {
+		return ""
+	}
+	return event.description[1 : end+1]
+}
+
+// isCloudInitFinalStage whether an event is cloud-init reporting on its final module stage, which runs the user data
+func isCloudInitFinalStage(event NodeEvent) bool {
+	return eventOrigin(event) == eventOriginCloudInit &&
+		strings.TrimSpace(event.description[len(eventOriginCloudInit)+2:]) == cloudInitFinalStageDescribe
+}
+
+// cloudInitProgress follow cloud-init on the deployed OS through the events logged since the deployment started.
+// The installer boots an ephemeral environment that reports cloud-init events of its own, so only the events
+// around the node's status change to Deployed are considered:
+//   - MAAS versions that end the deployment when the deployed OS finishes its final stage log that stage right
+//     before the status change, so cloud-init is done when the node becomes Deployed
+//   - older versions mark the node Deployed once the installer is done, and the deployed OS reports its final
+//     stage afterwards, once when it starts and once when it finishes
+//
+// A node that reported nothing while it was being deployed has nothing to wait for.
+// A cloud-init error logged after the status change fails the wait.
+func cloudInitProgress(events []NodeEvent) (string, *NodeEvent) {
+	deployed := -1
+	for i, event := range events {
+		if event.event_type == eventTypeNodeChangedStatus && strings.HasSuffix(event.description, eventStatusDeployed) {
+			deployed = i
+			break
+		}
+	}
+	if deployed < 0 {
+		return cloudInitRunning, nil
+	}
+
+	// the last message the node reported before it became Deployed, if it reported anything at all
+	reported := deployed - 1
+	for reported >= 0 && eventOrigin(events[reported]) == "" {
+		reported--
+	}
+	if reported < 0 || isCloudInitFinalStage(events[reported]) {
+		return cloudInitFinished, &events[deployed]
+	}
+
+	final_stage := 0
+	for i := deployed + 1; i < len(events); i++ {
+		event := events[i]
+		if eventOrigin(event) != eventOriginCloudInit {
+			continue
+		}
+
+		level := strings.ToUpper(event.level)
+		if event.event_type == eventTypeInstallFailure || level == "ERROR" || level == "CRITICAL" {
+			return cloudInitFailed, &events[i]
+		}
+
+		if isCloudInitFinalStage(event) {
+			final_stage++
+			if final_stage == 2 {
+				return cloudInitFinished, &events[i]
+			}
+		}
+	}
+	return cloudInitRunning, nil
+}
+
+// getNodeCloudInitStatus Convenience function used by resourceMAASInstanceCreate as a refresh function
+// to follow cloud-init's progress on a node through the MAAS event log.
+// Only events newer than after, the newest event from before the deployment started, are considered.
+func getNodeCloudInitStatus(maas *gomaasapi.MAASObject, system_id string, after int) resource.StateRefreshFunc {
+	log.Printf("[DEBUG] [getNodeCloudInitStatus] Following cloud-init on node: %s", system_id)
+	return func() (interface{}, string, error) {
+		events, err := maasQueryNodeEvents(maas, system_id, after)
+		if err != nil {
+			log.Printf("[ERROR] [getNodeCloudInitStatus] Unable to get events for node: %s\n", system_id)
+			return nil, "", err
+		}
+
+		state, event := cloudInitProgress(events)
+		switch state {
+		case cloudInitFailed:
+			return *event, cloudInitFailed, fmt.Errorf("cloud-init failed on node (%s): %s", system_id, event.description)
+		case cloudInitFinished:
+			return *event, cloudInitFinished, nil
+		}
+		return events, cloudInitRunning, nil
+	}
+}
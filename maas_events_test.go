@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/juju/gomaasapi"
+)
+
+// maasEvent a single entry of an events?op=query result for node-1
+func maasEvent(id int, level string, event_type string, description string) string {
+	return fmt.Sprintf(`{"username": "admin", "node": "node-1", "hostname": "node-1", "id": %d, "level": "%s", "created": "Tue, 13 Oct. 2026 09:%02d:00", "type": "%s", "description": "%s"}`,
+		id, level, id%60, event_type, strings.Replace(description, `"`, `\"`, -1))
+}
+
+// maasEventsQuery wrap the events, given oldest first, the way events?op=query returns them: newest first
+func maasEventsQuery(t *testing.T, events ...string) []NodeEvent {
+	reversed := make([]string, len(events))
+	for i, event := range events {
+		reversed[len(events)-1-i] = event
+	}
+	input := fmt.Sprintf(`{"count": %d, "events": [%s], "next_uri": "/MAAS/api/2.0/events/?op=query&id=node-1&after=100", "prev_uri": "/MAAS/api/2.0/events/?op=query&id=node-1&before=100"}`,
+		len(events), strings.Join(reversed, ", "))
+
+	result, err := gomaasapi.Parse(gomaasapi.Client{}, []byte(input))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	nodeEvents, err := toNodeEvents(result)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return nodeEvents
+}
+
+// the installer's ephemeral environment runs cloud-init, including its final stage, before curtin installs the OS
+var installerEvents = []string{
+	maasEvent(101, "INFO", "Deploying", ""),
+	maasEvent(102, "DEBUG", "Node changed status", "From 'Allocated' to 'Deploying'"),
+	maasEvent(103, "INFO", "Powering on", ""),
+	maasEvent(104, "DEBUG", "Node powered on", ""),
+	maasEvent(105, "INFO", "PXE Request - installation", "Machine powered on"),
+	maasEvent(106, "INFO", "Loading ephemeral", ""),
+	maasEvent(107, "DEBUG", "Node installation", "'cloudinit' running modules for config"),
+	maasEvent(108, "DEBUG", "Node installation", "'cloudinit' running modules for config"),
+	maasEvent(109, "DEBUG", "Node installation", "'cloudinit' running modules for final"),
+	maasEvent(110, "DEBUG", "Node installation", "'cloudinit' running config-scripts-user with frequency once-per-instance"),
+	maasEvent(111, "DEBUG", "Node installation", "'cloudinit' running config-scripts-user with frequency once-per-instance"),
+	maasEvent(112, "DEBUG", "Node installation", "'cloudinit' running modules for final"),
+	maasEvent(113, "INFO", "Installing OS", ""),
+	maasEvent(114, "DEBUG", "Node installation", "'curtin' curtin command install"),
+	maasEvent(115, "DEBUG", "Node installation", "'curtin' curtin command install"),
+	maasEvent(116, "INFO", "Configuring OS", ""),
+	maasEvent(117, "INFO", "Rebooting", ""),
+}
+
+// the deployed OS boots and runs cloud-init, with the user data in its final stage
+var deployedOSEvents = []string{
+	maasEvent(120, "DEBUG", "Node installation", "'cloudinit' running modules for config"),
+	maasEvent(121, "DEBUG", "Node installation", "'cloudinit' running modules for config"),
+	maasEvent(122, "DEBUG", "Node installation", "'cloudinit' running modules for final"),
+	maasEvent(123, "DEBUG", "Node installation", "'cloudinit' running config-scripts-user with frequency once-per-instance"),
+}
+
+var deployedEvents = []string{
+	maasEvent(130, "DEBUG", "Node changed status", "From 'Deploying' to 'Deployed'"),
+	maasEvent(131, "INFO", "Deployed", "Ubuntu 18.04 LTS"),
+}
+
+func joinEvents(parts ...[]string) []string {
+	events := []string{}
+	for _, part := range parts {
+		events = append(events, part...)
+	}
+	return events
+}
+
+func TestToNodeEvents(t *testing.T) {
+	events := maasEventsQuery(t, installerEvents[:2]...)
+	if len(events) != 2 || events[0].id != 101 || events[1].id != 102 {
+		t.Fatalf("events should be oldest first: %+v", events)
+	}
+	if events[1].level != "DEBUG" || events[1].event_type != "Node changed status" || events[1].description != "From 'Allocated' to 'Deploying'" {
+		t.Fatalf("bad event: %+v", events[1])
+	}
+}
+
+func TestEventOrigin(t *testing.T) {
+	cases := map[string]string{
+		"'cloudinit' running modules for final": "cloudinit",
+		"'curtin' curtin command install":       "curtin",
+		"From 'Deploying' to 'Deployed'":        "",
+		"Ubuntu 18.04 LTS":                      "",
+		"":                                      "",
+	}
+	for description, expected := range cases {
+		if origin := eventOrigin(NodeEvent{description: description}); origin != expected {
+			t.Fatalf("%q: got %q, expected %q", description, origin, expected)
+		}
+	}
+}
+
+func TestCloudInitProgress(t *testing.T) {
+	finishLine := maasEvent(124, "DEBUG", "Node installation", "'cloudinit' running config-scripts-user with frequency once-per-instance")
+	finalFinish := maasEvent(125, "DEBUG", "Node installation", "'cloudinit' running modules for final")
+
+	cases := []struct {
+		name     string
+		events   []string
+		expected string
+		id       int
+	}{
+		{
+			"the installer's final stage doesn't end the wait",
+			installerEvents,
+			cloudInitRunning, 0,
+		},
+		{
+			"MAAS ends the deployment on the deployed OS' final stage",
+			joinEvents(installerEvents, deployedOSEvents, []string{finishLine, finalFinish}, deployedEvents),
+			cloudInitFinished, 130,
+		},
+		{
+			"the node is Deployed before the deployed OS has booted",
+			joinEvents(installerEvents, deployedEvents),
+			cloudInitRunning, 0,
+		},
+		{
+			"the final stage has started but not finished",
+			joinEvents(installerEvents, deployedEvents, []string{
+				maasEvent(140, "DEBUG", "Node status event", "'cloudinit' running modules for config"),
+				maasEvent(141, "DEBUG", "Node status event", "'cloudinit' running modules for final"),
+			}),
+			cloudInitRunning, 0,
+		},
+		{
+			"the final stage finishes after the node is Deployed",
+			joinEvents(installerEvents, deployedEvents, []string{
+				maasEvent(140, "DEBUG", "Node status event", "'cloudinit' running modules for config"),
+				maasEvent(141, "DEBUG", "Node status event", "'cloudinit' running modules for final"),
+				maasEvent(142, "DEBUG", "Node status event", "'cloudinit' running modules for final"),
+			}),
+			cloudInitFinished, 142,
+		},
+		{
+			"the user data fails after the node is Deployed",
+			joinEvents(installerEvents, deployedEvents, []string{
+				maasEvent(140, "DEBUG", "Node status event", "'cloudinit' running modules for final"),
+				maasEvent(141, "ERROR", "Node status event", "'cloudinit' running config-scripts-user with frequency once-per-instance"),
+			}),
+			cloudInitFailed, 141,
+		},
+		{
+			"the node reports nothing while it is deployed",
+			joinEvents(installerEvents[:6], deployedEvents),
+			cloudInitFinished, 130,
+		},
+	}
+
+	for _, tc := range cases {
+		state, event := cloudInitProgress(maasEventsQuery(t, tc.events...))
+		if state != tc.expected {
+			t.Fatalf("%s: got %q, expected %q", tc.name, state, tc.expected)
+		}
+		if tc.id == 0 && event != nil {
+			t.Fatalf("%s: expected no event, got %+v", tc.name, event)
+		}
+		if tc.id != 0 && (event == nil || event.id != tc.id) {
+			t.Fatalf("%s: expected event %d, got %+v", tc.name, tc.id, event)
+		}
+	}
+}
@@ -9,12 +9,18 @@ import (
 
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/juju/gomaasapi"
 )
 
 // resourceMAASInstanceCreate This function doesn't really *create* a new node but, power an already registered
 func resourceMAASInstanceCreate(d *schema.ResourceData, meta interface{}) error {
 	log.Println("[DEBUG] [resourceMAASInstanceCreate] Launching new maas_instance")
 
+	// take ownership of an already deployed node instead of allocating a new one
+	if adoptRequested(d) {
+		return resourceMAASInstanceAdopt(d, meta)
+	}
+
 	/*
 		According to the MAAS API documentation here: https://maas.ubuntu.com/docs/api.html
 		We need to acquire or allocate a node before we start it.  We pass (as url.Values)
@@ -27,22 +33,181 @@ func resourceMAASInstanceCreate(d *schema.ResourceData, meta interface{}) error
 		return err
 	}
 
-	nodeObj, err := nodesAllocate(meta.(*Config).MAASObject, constraints)
+	// check the storage layout before a node is allocated
+	var storage_params url.Values
+	if _, ok := d.GetOk("storage_layout"); ok {
+		if storage_params, err = resourceMAASInstanceStorageLayoutParams(d); err != nil {
+			log.Println("[ERROR] [resourceMAASInstanceCreate] Invalid storage layout.")
+			return err
+		}
+	}
+
+	// check the interface links before a node is allocated
+	if _, ok := d.GetOk("network_interface"); ok {
+		if err := validateNetworkInterfaceConfigs(toNetworkInterfaceConfigs(d)); err != nil {
+			log.Println("[ERROR] [resourceMAASInstanceCreate] Invalid network interface configuration.")
+			return err
+		}
+	}
+
+	node_params, err := resourceMAASInstanceDeployParams(d, meta)
 	if err != nil {
-		log.Println("[ERROR] [resourceMAASInstanceCreate] Unable to allocate nodes")
 		return err
 	}
 
-	// set the node id
-	d.SetId(nodeObj.system_id)
+	// allocate the machine released by the destroy half of a sticky replacement again
+	sticky_id := ""
+	if d.Get("sticky_machine").(bool) && stickyMachineKey(d) == "" {
+		log.Printf("[WARN] [resourceMAASInstanceCreate] The resource address is unknown, sticky_machine has no effect")
+	}
+	if key := stickyMachineKey(d); key != "" {
+		sticky_id = meta.(*Config).sticky.pop(key)
+		if sticky_id != "" {
+			log.Printf("[DEBUG] [resourceMAASInstanceCreate] Re-allocating sticky node (%s)", sticky_id)
+		}
+	}
+
+	retries := d.Get("deploy_retries").(int)
+	attempts := make([]string, 0, retries+1)
+
+	// failed nodes that are released stay allocated until the retries are over, so none of them is picked again
+	held := []string{}
+	defer func() {
+		for _, system_id := range held {
+			if err := nodeRelease(meta.(*Config).MAASObject, system_id, url.Values{}); err != nil {
+				log.Printf("[DEBUG] Unable to release node (%s)", system_id)
+			}
+		}
+	}()
+
+	for attempt := 0; ; attempt++ {
+		attempt_constraints := constraints
+		if sticky_id != "" && attempt == 0 {
+			attempt_constraints = url.Values{}
+			attempt_constraints.Set("system_id", sticky_id)
+		}
+
+		nodeObj, err := resourceMAASInstanceAllocate(d, meta, attempt_constraints)
+		if err != nil {
+			log.Println("[ERROR] [resourceMAASInstanceCreate] Unable to allocate nodes")
+			if sticky_id != "" && attempt == 0 {
+				owner := "unknown"
+				if node, err := getSingleNode(meta.(*Config).MAASObject, sticky_id); err == nil {
+					owner = node.owner
+				}
+				return fmt.Errorf("[ERROR] [resourceMAASInstanceCreate] Unable to re-allocate sticky node (%s), it may have been allocated by another user (owner: %s): %s", sticky_id, owner, err)
+			}
+			if attempt > 0 {
+				d.SetId("")
+				return fmt.Errorf("[ERROR] [resourceMAASInstanceCreate] Unable to allocate a node to retry the deployment after nodes %v failed: %s", attempts, err)
+			}
+			return err
+		}
+
+		// set the node id
+		d.SetId(nodeObj.system_id)
+
+		attempts = append(attempts, nodeObj.system_id)
+		d.Set("deploy_attempts", attempts)
+
+		// remember what the node looked like before we touched it so it can be restored on release
+		d.Set("original_hostname", nodeObj.hostname)
+		d.Set("original_tags", nodeObj.tag_names)
+
+		err = resourceMAASInstanceDeploy(d, meta, storage_params, node_params)
+		if err == nil {
+			break
+		}
+
+		if _, ok := d.GetOk("kernel_options"); ok {
+			if err := nodeKernelOptionsRemove(meta.(*Config).MAASObject, d.Id()); err != nil {
+				log.Printf("[DEBUG] Unable to remove kernel options")
+			}
+		}
+		if _, ok := d.GetOk("min_hwe_kernel"); ok {
+			if err := nodeMinHWEKernelClear(meta.(*Config).MAASObject, d.Id()); err != nil {
+				log.Printf("[DEBUG] Unable to clear minimum kernel")
+			}
+		}
+
+		failure, ok := err.(*deploymentFailedError)
+		if !ok {
+			// the node itself isn't to blame, so another one would fail the same way
+			if err := nodeRelease(meta.(*Config).MAASObject, d.Id(), url.Values{}); err != nil {
+				log.Printf("[DEBUG] Unable to release node")
+			}
+			d.SetId("")
+			return err
+		}
 
+		log.Printf("[ERROR] [resourceMAASInstanceCreate] Deployment attempt %d of %d failed: %s", attempt+1, retries+1, failure)
+
+		if d.Get("deploy_retry_failed_action").(string) == deployFailedMarkBroken {
+			params := url.Values{}
+			params.Set("comment", fmt.Sprintf("Deployment failed: %s", failure.err))
+			if err := nodeDo(meta.(*Config).MAASObject, d.Id(), "mark_broken", params); err != nil {
+				log.Printf("[ERROR] [resourceMAASInstanceCreate] Unable to mark node (%s) broken: %s", d.Id(), err)
+				held = append(held, d.Id())
+			}
+		} else {
+			held = append(held, d.Id())
+		}
+
+		if attempt >= retries {
+			d.SetId("")
+			return fmt.Errorf("[ERROR] [resourceMAASInstanceCreate] Deployment failed on nodes %v: %s", attempts, failure)
+		}
+	}
+
+	resourceMAASInstanceApplyDeploySettings(d, meta)
+
+	return resourceMAASInstanceUpdate(d, meta)
+}
+
+// deploy_retry_failed_action values
+const (
+	deployFailedMarkBroken = "mark_broken"
+	deployFailedRelease    = "release"
+)
+
+// deploymentFailedError the node was deployed but never reached the Deployed state
+type deploymentFailedError struct {
+	system_id string
+	err       error
+}
+
+func (e *deploymentFailedError) Error() string {
+	return fmt.Sprintf("Error waiting for instance (%s) to become deployed: %s", e.system_id, e.err)
+}
+
+// resourceMAASInstanceDeployParams build the parameters of the deploy operation
+func resourceMAASInstanceDeployParams(d *schema.ResourceData, meta interface{}) (url.Values, error) {
 	// seperate constraints that are supported for the deploy action
-	// parameters to pass when creating a node
-	node_params := url.Values{}
+	// parameters to pass when creating a node, starting with the options that depend on the MAAS version
+	node_params, err := resourceMAASInstanceDeployOptions(d, meta)
+	if err != nil {
+		log.Println("[ERROR] [resourceMAASInstanceDeployParams] Unsupported deploy option.")
+		return nil, err
+	}
 
-	// get user data if defined
+	// get user data if defined, either as plain text or base64
 	if user_data, ok := d.GetOk("user_data"); ok {
-		node_params.Add("user_data", base64encode(user_data.(string)))
+		node_params.Add("user_data", userDataEncode(user_data.(string)))
+	}
+
+	// get base64 encoded user data if defined
+	if user_data, ok := d.GetOk("user_data_base64"); ok {
+		node_params.Add("user_data", user_data.(string))
+	}
+
+	// assemble the user data from its parts if defined
+	if _, ok := d.GetOk("cloud_init_part"); ok {
+		user_data, err := renderCloudInitParts(toCloudInitParts(d), d.Get("cloud_init_gzip").(bool))
+		if err != nil {
+			log.Println("[ERROR] [resourceMAASInstanceDeployParams] Unable to render cloud-init parts.")
+			return nil, err
+		}
+		node_params.Add("user_data", base64encode(user_data))
 	}
 
 	// get comment if defined
@@ -56,16 +221,70 @@ func resourceMAASInstanceCreate(d *schema.ResourceData, meta interface{}) error
 		node_params.Add("distro_series", distro_series.(string))
 	}
 
-	if err := nodeDo(meta.(*Config).MAASObject, d.Id(), "deploy", node_params); err != nil {
-		log.Printf("[ERROR] [resourceMAASInstanceCreate] Unable to power up node: %s\n", d.Id())
-		// unable to perform action, release the node
-		if err := nodeRelease(meta.(*Config).MAASObject, d.Id(), url.Values{}); err != nil {
-			log.Printf("[DEBUG] Unable to release node")
+	return node_params, nil
+}
+
+// resourceMAASInstanceDeploy configure and deploy the allocated node, waiting for the deployment to finish.
+// A node that never reaches the Deployed state is reported with a deploymentFailedError.
+// The node is left allocated on failure.
+func resourceMAASInstanceDeploy(d *schema.ResourceData, meta interface{}, storage_params url.Values, node_params url.Values) error {
+	// configure the node's interfaces before it is deployed
+	if _, ok := d.GetOk("network_interface"); ok {
+		if err := resourceMAASInstanceConfigureInterfaces(d, meta); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceDeploy] Unable to configure the interfaces of node: %s\n", d.Id())
+			return err
+		}
+	}
+
+	// apply the storage layout before the node is deployed
+	if storage_params != nil {
+		if err := nodeSetStorageLayout(meta.(*Config).MAASObject, d.Id(), storage_params); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceDeploy] Unable to set the storage layout of node: %s\n", d.Id())
+			return err
 		}
+	}
+
+	// partition, format and mount the node's block devices before it is deployed
+	if _, ok := d.GetOk("block_device"); ok {
+		if err := resourceMAASInstanceConfigureBlockDevices(d, meta); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceDeploy] Unable to configure the block devices of node: %s\n", d.Id())
+			return err
+		}
+	}
+
+	// the kernel options are picked up from the node's tags when it is deployed
+	if kernel_options, ok := d.GetOk("kernel_options"); ok {
+		if err := nodeKernelOptionsSet(meta.(*Config).MAASObject, d.Id(), kernel_options.(string)); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceDeploy] Unable to set the kernel options of node: %s\n", d.Id())
+			return err
+		}
+	}
+
+	if min_hwe_kernel, ok := d.GetOk("min_hwe_kernel"); ok {
+		params := url.Values{}
+		params.Set("min_hwe_kernel", min_hwe_kernel.(string))
+		if err := nodeUpdate(meta.(*Config).MAASObject, d.Id(), params); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceDeploy] Unable to set the minimum HWE kernel of node: %s\n", d.Id())
+			return err
+		}
+	}
+
+	// remember where the event log was so only events from this deployment are considered
+	last_event_id := 0
+	if d.Get("wait_for_cloud_init").(bool) {
+		var err error
+		// without it an earlier deployment's cloud-init events would end the wait straight away
+		if last_event_id, err = latestNodeEventID(meta.(*Config).MAASObject, d.Id()); err != nil {
+			return fmt.Errorf("[ERROR] [resourceMAASInstanceDeploy] Unable to get the latest event for node (%s): %s", d.Id(), err)
+		}
+	}
+
+	if err := nodeDo(meta.(*Config).MAASObject, d.Id(), "deploy", node_params); err != nil {
+		log.Printf("[ERROR] [resourceMAASInstanceDeploy] Unable to power up node: %s\n", d.Id())
 		return err
 	}
 
-	log.Printf("[DEBUG] [resourceMAASInstanceCreate] Waiting for instance (%s) to become active\n", d.Id())
+	log.Printf("[DEBUG] [resourceMAASInstanceDeploy] Waiting for instance (%s) to become active\n", d.Id())
 	stateConf := &resource.StateChangeConf{
 		Pending:    []string{"9:"},
 		Target:     []string{"6:"},
@@ -76,12 +295,30 @@ func resourceMAASInstanceCreate(d *schema.ResourceData, meta interface{}) error
 	}
 
 	if _, err := stateConf.WaitForState(); err != nil {
-		if err := nodeRelease(meta.(*Config).MAASObject, d.Id(), url.Values{}); err != nil {
-			log.Printf("[DEBUG] Unable to release node")
+		return &deploymentFailedError{system_id: d.Id(), err: err}
+	}
+
+	if d.Get("wait_for_cloud_init").(bool) {
+		log.Printf("[DEBUG] [resourceMAASInstanceDeploy] Waiting for cloud-init to finish on instance (%s)\n", d.Id())
+		stateConf := &resource.StateChangeConf{
+			Pending:    []string{cloudInitRunning},
+			Target:     []string{cloudInitFinished},
+			Refresh:    getNodeCloudInitStatus(meta.(*Config).MAASObject, d.Id(), last_event_id),
+			Timeout:    30 * time.Minute,
+			Delay:      10 * time.Second,
+			MinTimeout: 3 * time.Second,
+		}
+
+		if _, err := stateConf.WaitForState(); err != nil {
+			return fmt.Errorf("[ERROR] [resourceMAASInstanceDeploy] Error waiting for cloud-init to finish on instance (%s): %s", d.Id(), err)
 		}
-		return fmt.Errorf("[ERROR] [resourceMAASInstanceCreate] Error waiting for instance (%s) to become deployed: %s", d.Id(), err)
 	}
 
+	return nil
+}
+
+// resourceMAASInstanceApplyDeploySettings set the deploy hostname and tags of a deployed node
+func resourceMAASInstanceApplyDeploySettings(d *schema.ResourceData, meta interface{}) {
 	// update node
 	params := url.Values{}
 	if hostname, ok := d.GetOk("deploy_hostname"); ok {
@@ -89,105 +326,563 @@ func resourceMAASInstanceCreate(d *schema.ResourceData, meta interface{}) error
 	}
 
 	// only updating hostname
-	err = nodeUpdate(meta.(*Config).MAASObject, d.Id(), params)
+	err := nodeUpdate(meta.(*Config).MAASObject, d.Id(), params)
 	if err != nil {
 		log.Println("[DEBUG] Unable to update node")
 	}
 
 	// update node tags
 	if tags, ok := d.GetOk("deploy_tags"); ok {
-		for i := range tags.([]interface{}) {
-			err := nodeTagsUpdate(meta.(*Config).MAASObject, d.Id(), tags.([]interface{})[i].(string))
+		for _, tag := range tags.(*schema.Set).List() {
+			err := nodeTagsUpdate(meta.(*Config).MAASObject, d.Id(), tag.(string))
 			if err != nil {
-				log.Printf("[ERROR] Unable to update node (%s) with tag (%s)", d.Id(), tags.([]interface{})[i].(string))
+				log.Printf("[ERROR] Unable to update node (%s) with tag (%s)", d.Id(), tag.(string))
 			}
 		}
 	}
+}
+
+// adoptRequested Convenience function to check whether the resource should adopt an existing node
+func adoptRequested(d *schema.ResourceData) bool {
+	_, bySystemID := d.GetOk("adopt_system_id")
+	_, byHostname := d.GetOk("adopt_hostname")
+	return bySystemID || byHostname
+}
+
+// resourceMAASInstanceAdopt take ownership of a node that is already deployed by the same MAAS user,
+// skipping allocation and deployment
+func resourceMAASInstanceAdopt(d *schema.ResourceData, meta interface{}) error {
+	maas := meta.(*Config).MAASObject
+
+	var nodeObj *NodeInfo
+	if system_id, ok := d.GetOk("adopt_system_id"); ok {
+		node, err := getSingleNode(maas, system_id.(string))
+		if err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceAdopt] Unable to get node (%s)", system_id)
+			return err
+		}
+		nodeObj = node
+	} else {
+		hostname := d.Get("adopt_hostname").(string)
+		nodes, err := getAllNodes(maas)
+		if err != nil {
+			return err
+		}
+		for i := range nodes {
+			if nodes[i].hostname == hostname || nodes[i].fqdn == hostname {
+				nodeObj = &nodes[i]
+				break
+			}
+		}
+		if nodeObj == nil {
+			return fmt.Errorf("[ERROR] [resourceMAASInstanceAdopt] Unable to find a node named %s", hostname)
+		}
+	}
+
+	log.Printf("[DEBUG] [resourceMAASInstanceAdopt] Adopting node (%s)", nodeObj.system_id)
+
+	if nodeObj.status != nodeStatusDeployed {
+		return fmt.Errorf("[ERROR] [resourceMAASInstanceAdopt] Node (%s) is not deployed (status %d)", nodeObj.system_id, nodeObj.status)
+	}
+
+	user, err := maasWhoAmI(maas)
+	if err != nil {
+		return err
+	}
+	if nodeObj.owner != user {
+		return fmt.Errorf("[ERROR] [resourceMAASInstanceAdopt] Node (%s) is owned by %q, not %q", nodeObj.system_id, nodeObj.owner, user)
+	}
+
+	d.SetId(nodeObj.system_id)
+
+	d.Set("original_hostname", nodeObj.hostname)
+	d.Set("original_tags", nodeObj.tag_names)
+
+	resourceMAASInstanceApplyDeploySettings(d, meta)
 
 	return resourceMAASInstanceUpdate(d, meta)
 }
 
 // resourceMAASInstanceRead read instance information from a maas node
-// TODO: remove or do something
 func resourceMAASInstanceRead(d *schema.ResourceData, meta interface{}) error {
 	log.Printf("[DEBUG] Reading instance (%s) information.\n", d.Id())
+
+	nodeObj, err := getSingleNode(meta.(*Config).MAASObject, d.Id())
+	if err != nil {
+		if isNotFoundError(err) {
+			log.Printf("[WARN] [resourceMAASInstanceRead] Node (%s) no longer exists", d.Id())
+			d.SetId("")
+			return nil
+		}
+		log.Printf("[ERROR] [resourceMAASInstanceRead] Unable to read node (%s)", d.Id())
+		return err
+	}
+
+	d.Set("power_state", nodeObj.power_state)
+
+	// a node powered on or off outside terraform shows up as a change to desired_power_state, so it gets corrected
+	if desired_power_state, ok := d.GetOk("desired_power_state"); ok && desired_power_state.(string) != nodeObj.power_state {
+		if nodeObj.power_state == powerStateOn || nodeObj.power_state == powerStateOff {
+			log.Printf("[WARN] [resourceMAASInstanceRead] Node (%s) is powered %s instead of %s", d.Id(), nodeObj.power_state, desired_power_state)
+			d.Set("desired_power_state", nodeObj.power_state)
+		}
+	}
+
+	d.Set("fqdn", nodeObj.fqdn)
+	d.Set("architecture", nodeObj.architecture)
+	d.Set("owner", nodeObj.owner)
+	d.Set("zone", nodeObj.zone)
+	d.Set("pool", nodeObj.pool)
+	d.Set("pxe_mac", nodeObj.boot_mac)
+	d.Set("locked", nodeObj.locked)
+	d.Set("tag_names", nodeObj.tag_names)
+	d.Set("rescue_mode", nodeObj.status == nodeStatusRescueMode)
+
+	if err := d.Set("ip_addresses", nodeObj.ip_addresses); err != nil {
+		return err
+	}
+	if err := d.Set("interfaces", flattenNodeInterfaces(nodeObj.interfaces)); err != nil {
+		return err
+	}
+	if err := d.Set("macaddress_set", flattenNodeMACAddresses(nodeObj.interfaces)); err != nil {
+		return err
+	}
+	if err := d.Set("physicalblockdevice_set", flattenNodeBlockDevices(nodeObj.block_devices)); err != nil {
+		return err
+	}
+
+	if d.Get("install_kvm").(bool) || d.Get("register_vmhost").(bool) {
+		vmhost_id, err := maasFindVMHost(meta.(*Config).MAASObject, d.Id())
+		if err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceRead] Unable to find the VM host of node (%s)", d.Id())
+			return err
+		}
+		d.Set("vmhost_id", vmhost_id)
+	}
+
+	if _, ok := d.GetOk("network_interface"); ok {
+		controller, err := meta.(*Config).Controller()
+		if err != nil {
+			return err
+		}
+
+		machine, err := controllerGetMachine(controller, d.Id())
+		if err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceRead] Unable to read the interfaces of node (%s)", d.Id())
+			return err
+		}
+
+		if err := d.Set("network_interface", flattenNetworkInterfaces(machine, toNetworkInterfaceConfigs(d))); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// flattenNodeInterfaces convert a node's interfaces to the interfaces attribute
+func flattenNodeInterfaces(interfaces []NodeInterface) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(interfaces))
+	for _, iface := range interfaces {
+		subnets := make([]string, 0, len(iface.Links))
+		ip_addresses := make([]string, 0, len(iface.Links))
+		for _, link := range iface.Links {
+			if link.Subnet != nil {
+				subnets = append(subnets, link.Subnet.CIDR)
+			}
+			if link.IPAddress != "" {
+				ip_addresses = append(ip_addresses, link.IPAddress)
+			}
+		}
+
+		item := map[string]interface{}{
+			"name":         iface.Name,
+			"mac_address":  iface.MACAddress,
+			"subnets":      subnets,
+			"ip_addresses": ip_addresses,
+		}
+		if iface.VLAN != nil {
+			item["vlan"] = iface.VLAN.VID
+			item["fabric"] = iface.VLAN.Fabric
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// flattenNodeMACAddresses convert a node's interfaces to the macaddress_set attribute
+func flattenNodeMACAddresses(interfaces []NodeInterface) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(interfaces))
+	for _, iface := range interfaces {
+		result = append(result, map[string]interface{}{
+			"mac_address": iface.MACAddress,
+		})
+	}
+	return result
+}
+
+// flattenNodeBlockDevices convert a node's physical block devices to the physicalblockdevice_set attribute
+func flattenNodeBlockDevices(devices []NodeBlockDevice) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(devices))
+	for _, device := range devices {
+		result = append(result, map[string]interface{}{
+			"id":         device.ID,
+			"name":       device.Name,
+			"path":       device.Path,
+			"id_path":    device.IDPath,
+			"model":      device.Model,
+			"serial":     device.Serial,
+			"size":       int(device.Size),
+			"block_size": int(device.BlockSize),
+			"tags":       device.Tags,
+		})
+	}
+	return result
+}
+
+// resourceMAASInstanceConfigureBlockDevices partition the allocated node's block devices as requested by the block_device blocks
+func resourceMAASInstanceConfigureBlockDevices(d *schema.ResourceData, meta interface{}) error {
+	controller, err := meta.(*Config).Controller()
+	if err != nil {
+		return err
+	}
+
+	return nodeConfigureBlockDevices(meta.(*Config).MAASObject, controller, d.Id(), toBlockDeviceConfigs(d))
+}
+
+// resourceMAASInstanceConfigureInterfaces link the allocated node's interfaces as requested by the network_interface blocks
+func resourceMAASInstanceConfigureInterfaces(d *schema.ResourceData, meta interface{}) error {
+	controller, err := meta.(*Config).Controller()
+	if err != nil {
+		return err
+	}
+
+	return nodeConfigureInterfaces(meta.(*Config).MAASObject, controller, d.Id(), toNetworkInterfaceConfigs(d))
+}
+
 // resourceMAASInstanceUpdate update an instance in terraform state
 func resourceMAASInstanceUpdate(d *schema.ResourceData, meta interface{}) error {
 	log.Printf("[DEBUG] [resourceMAASInstanceUpdate] Modifying instance %s\n", d.Id())
 
 	d.Partial(true)
 
+	// a locked node can't be powered on or off, so unlock it first and lock it last
+	locked := d.Get("locked").(bool)
+	if d.HasChange("locked") && !locked {
+		if err := nodeDo(meta.(*Config).MAASObject, d.Id(), "unlock", url.Values{}); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceUpdate] Unable to unlock node (%s)", d.Id())
+			return err
+		}
+		d.SetPartial("locked")
+	}
+
+	// a node that stays locked is only unlocked for the duration of the actions, and locked again even if one fails
+	actions := d.HasChange("desired_power_state") || (d.HasChange("power_cycle_trigger") && !d.IsNewResource()) ||
+		d.HasChange("rescue_mode") || d.HasChange("run_tests")
+	if locked && !d.HasChange("locked") && actions {
+		if err := nodeDo(meta.(*Config).MAASObject, d.Id(), "unlock", url.Values{}); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceUpdate] Unable to unlock node (%s)", d.Id())
+			return err
+		}
+		err := resourceMAASInstanceUpdateActions(d, meta)
+		if lockErr := nodeLock(meta.(*Config).MAASObject, d.Id()); lockErr != nil && err == nil {
+			err = lockErr
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := resourceMAASInstanceUpdateActions(d, meta); err != nil {
+		return err
+	}
+
+	if d.HasChange("locked") && locked {
+		if err := nodeLock(meta.(*Config).MAASObject, d.Id()); err != nil {
+			return err
+		}
+		d.SetPartial("locked")
+	}
+
 	d.Partial(false)
 
 	log.Printf("[DEBUG] Done Modifying instance %s", d.Id())
 	return resourceMAASInstanceRead(d, meta)
 }
 
-// resourceMAASInstanceDelete This function doesn't really *delete* a maas managed instance but releases (read, turns off) the node.
-func resourceMAASInstanceDelete(d *schema.ResourceData, meta interface{}) error {
-	log.Printf("[DEBUG] Deleting instance %s\n", d.Id())
-	release_params := url.Values{}
+// resourceMAASInstanceUpdateActions run the power, rescue mode and test actions whose attributes changed
+func resourceMAASInstanceUpdateActions(d *schema.ResourceData, meta interface{}) error {
+	if d.HasChange("desired_power_state") {
+		if desired_power_state, ok := d.GetOk("desired_power_state"); ok {
+			if err := nodeSetPowerState(meta.(*Config).MAASObject, d.Id(), desired_power_state.(string)); err != nil {
+				return err
+			}
+		}
+		d.SetPartial("desired_power_state")
+	}
 
-	if release_erase, ok := d.GetOk("release_erase"); ok {
-		release_params.Add("erase", strconv.FormatBool(release_erase.(bool)))
+	// the trigger only means something once the node has been deployed
+	if d.HasChange("power_cycle_trigger") && !d.IsNewResource() {
+		if err := nodePowerCycle(meta.(*Config).MAASObject, d.Id()); err != nil {
+			return err
+		}
+		d.SetPartial("power_cycle_trigger")
 	}
 
-	if release_erase_secure, ok := d.GetOk("release_erase_secure"); ok {
-		// setting erase to true in the event a user didn't set both options
-		release_params.Add("erase", strconv.FormatBool(true))
-		release_params.Add("secure_erase", strconv.FormatBool(release_erase_secure.(bool)))
+	if d.HasChange("rescue_mode") {
+		if err := nodeSetRescueMode(meta.(*Config).MAASObject, d.Id(), d.Get("rescue_mode").(bool)); err != nil {
+			return err
+		}
+		d.SetPartial("rescue_mode")
 	}
 
-	if release_erase_quick, ok := d.GetOk("release_erase_quick"); ok {
-		// setting erase to true in the event a user didn't set both options
-		release_params.Add("erase", strconv.FormatBool(true))
-		release_params.Add("quick_erase", strconv.FormatBool(release_erase_quick.(bool)))
+	// the tests run whenever the list changes, keeping the node deployed
+	if d.HasChange("run_tests") {
+		scripts := []string{}
+		for _, script := range d.Get("run_tests").([]interface{}) {
+			scripts = append(scripts, script.(string))
+		}
+
+		if len(scripts) > 0 {
+			results, err := nodeRunTests(meta.(*Config).MAASObject, d.Id(), scripts)
+			if results == nil {
+				return err
+			}
+
+			// failed tests still ran, so keep their results and don't run them again on the next apply
+			d.Set("test_results", flattenNodeTestResults(results))
+			d.SetPartial("test_results")
+			d.SetPartial("run_tests")
+			if err != nil {
+				return err
+			}
+		}
+		d.SetPartial("run_tests")
 	}
 
-	if err := nodeRelease(meta.(*Config).MAASObject, d.Id(), release_params); err != nil {
+	return nil
+}
+
+// nodeLock lock a deployed node so it can't be changed outside terraform
+func nodeLock(maas *gomaasapi.MAASObject, system_id string) error {
+	params := url.Values{}
+	params.Set("comment", "Locked by Terraform")
+	if err := nodeDo(maas, system_id, "lock", params); err != nil {
+		log.Printf("[ERROR] [nodeLock] Unable to lock node (%s)", system_id)
+		return err
+	}
+	return nil
+}
+
+// nodeMinHWEKernelClear reset the minimum hardware enablement kernel of a released node to the MAAS default
+func nodeMinHWEKernelClear(maas *gomaasapi.MAASObject, system_id string) error {
+	params := url.Values{}
+	params.Set("min_hwe_kernel", "")
+	return nodeUpdate(maas, system_id, params)
+}
+
+// power_state values
+const (
+	powerStateOn  = "on"
+	powerStateOff = "off"
+)
+
+// nodeSetPowerState power a deployed node on or off and wait for the BMC to report the new state
+func nodeSetPowerState(maas *gomaasapi.MAASObject, system_id string, state string) error {
+	log.Printf("[DEBUG] [nodeSetPowerState] Powering node (%s) %s", system_id, state)
+
+	current, err := maasQueryPowerState(maas, system_id)
+	if err != nil {
+		return err
+	}
+	if current == state {
+		log.Printf("[DEBUG] [nodeSetPowerState] Node (%s) is already powered %s", system_id, state)
+		return nil
+	}
+
+	action := "power_on"
+	pending := []string{powerStateOff, "unknown"}
+	if state == powerStateOff {
+		action = "power_off"
+		pending = []string{powerStateOn, "unknown"}
+	}
+
+	if err := nodeDo(maas, system_id, action, url.Values{}); err != nil {
+		log.Printf("[ERROR] [nodeSetPowerState] Unable to power node (%s) %s", system_id, state)
 		return err
 	}
 
 	stateConf := &resource.StateChangeConf{
-		Pending:    []string{"6:", "12:", "14:"},
-		Target:     []string{"4:"},
-		Refresh:    getNodeStatus(meta.(*Config).MAASObject, d.Id()),
-		Timeout:    30 * time.Minute,
-		Delay:      10 * time.Second,
+		Pending:    pending,
+		Target:     []string{state},
+		Refresh:    getNodePowerState(maas, system_id),
+		Timeout:    10 * time.Minute,
+		Delay:      5 * time.Second,
 		MinTimeout: 3 * time.Second,
 	}
 
 	if _, err := stateConf.WaitForState(); err != nil {
-		return fmt.Errorf(
-			"[ERROR] [resourceMAASInstanceCreate] Error waiting for instance (%s) to become ready: %s", d.Id(), err)
+		return fmt.Errorf("[ERROR] [nodeSetPowerState] Error waiting for node (%s) to power %s: %s", system_id, state, err)
+	}
+	return nil
+}
+
+// nodePowerCycle power a deployed node off and back on again
+func nodePowerCycle(maas *gomaasapi.MAASObject, system_id string) error {
+	log.Printf("[DEBUG] [nodePowerCycle] Power cycling node (%s)", system_id)
+
+	if err := nodeSetPowerState(maas, system_id, powerStateOff); err != nil {
+		return err
+	}
+	return nodeSetPowerState(maas, system_id, powerStateOn)
+}
+
+// resourceMAASInstanceDelete This function doesn't really *delete* a maas managed instance but releases (read, turns off) the node.
+func resourceMAASInstanceDelete(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Deleting instance %s\n", d.Id())
+
+	if !d.Get("release_on_destroy").(bool) {
+		log.Printf("[DEBUG] [resourceMAASInstanceDelete] Forgetting node (%s) without releasing it", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	// Delete can't tell a replacement from a destroy, so a sticky machine is always kept for a create at the same
+	// address, which only the create half of a replacement is
+	sticky_key := stickyMachineKey(d)
+
+	release_params := releaseEraseParams(d.Get("release_erase_mode").(string))
+
+	// get release comment if defined
+	if comment, ok := d.GetOk("release_comment"); ok {
+		release_params.Set("comment", comment.(string))
+	}
+
+	// force the release of nodes that are stuck deploying or failed
+	if d.Get("force_release").(bool) {
+		release_params.Set("force", strconv.FormatBool(true))
 	}
 
-	// remove deploy hostname if set
+	// MAAS refuses to release a locked node
+	if d.Get("locked").(bool) {
+		if err := nodeDo(meta.(*Config).MAASObject, d.Id(), "unlock", url.Values{}); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceDelete] Unable to unlock node (%s)", d.Id())
+			return err
+		}
+	}
+
+	if err := nodeRelease(meta.(*Config).MAASObject, d.Id(), release_params); err != nil {
+		return err
+	}
+
+	// a sticky node has to be ready before it can be allocated again
+	if d.Get("wait_for_release").(bool) || sticky_key != "" {
+		stateConf := &resource.StateChangeConf{
+			Pending:    []string{"6:", "9:", "10:", "11:", "12:", "14:"},
+			Target:     []string{"4:"},
+			Refresh:    getNodeStatus(meta.(*Config).MAASObject, d.Id()),
+			Timeout:    30 * time.Minute,
+			Delay:      10 * time.Second,
+			MinTimeout: 3 * time.Second,
+		}
+
+		if _, err := stateConf.WaitForState(); err != nil {
+			return fmt.Errorf(
+				"[ERROR] [resourceMAASInstanceDelete] Error waiting for instance (%s) to become ready: %s", d.Id(), err)
+		}
+	} else {
+		log.Printf("[DEBUG] [resourceMAASInstanceDelete] Not waiting for node (%s) to finish releasing", d.Id())
+	}
+
+	// restore the original hostname if a deploy hostname was set
 	if _, ok := d.GetOk("deploy_hostname"); ok {
 		params := url.Values{}
-		params.Set("hostname", "")
+		params.Set("hostname", d.Get("original_hostname").(string))
 		err := nodeUpdate(meta.(*Config).MAASObject, d.Id(), params)
 		if err != nil {
-			log.Println("[DEBUG] Unable to reset hostname: %s", err)
+			log.Printf("[DEBUG] Unable to restore hostname: %s", err)
 		}
 	}
 
-	// remove deployed tags
+	// restore the original tags
 	if tags, ok := d.GetOk("deploy_tags"); ok {
-		for i := range tags.([]interface{}) {
-			err := nodeTagsRemove(meta.(*Config).MAASObject, d.Id(), tags.([]interface{})[i].(string))
-			if err != nil {
-				log.Printf("[ERROR] Unable to update node (%s) with tag (%s)", d.Id(), tags.([]interface{})[i].(string))
+		original_tags := make([]string, 0)
+		if v, ok := d.GetOk("original_tags"); ok {
+			for _, tag := range v.(*schema.Set).List() {
+				original_tags = append(original_tags, tag.(string))
 			}
 		}
+
+		deploy_tags := make([]string, 0, tags.(*schema.Set).Len())
+		for _, tag := range tags.(*schema.Set).List() {
+			deploy_tags = append(deploy_tags, tag.(string))
+		}
+
+		if err := nodeTagsRestore(meta.(*Config).MAASObject, d.Id(), original_tags, deploy_tags); err != nil {
+			log.Printf("[ERROR] Unable to restore node (%s) tags: %s", d.Id(), err)
+		}
+	}
+
+	if _, ok := d.GetOk("kernel_options"); ok {
+		if err := nodeKernelOptionsRemove(meta.(*Config).MAASObject, d.Id()); err != nil {
+			log.Printf("[ERROR] Unable to remove node (%s) kernel options: %s", d.Id(), err)
+		}
+	}
+
+	// the minimum kernel would otherwise apply to whoever deploys the node next
+	if _, ok := d.GetOk("min_hwe_kernel"); ok {
+		if err := nodeMinHWEKernelClear(meta.(*Config).MAASObject, d.Id()); err != nil {
+			log.Printf("[ERROR] Unable to clear node (%s) minimum kernel: %s", d.Id(), err)
+		}
 	}
 
 	log.Printf("[DEBUG] [resourceMAASInstanceDelete] Node (%s) released", d.Id())
 
+	if sticky_key != "" {
+		meta.(*Config).sticky.push(sticky_key, d.Id())
+	}
+
 	d.SetId("")
 
 	return nil
 }
+
+// release_erase_mode values
+const (
+	releaseEraseNone          = "none"
+	releaseEraseFull          = "full"
+	releaseEraseSecure        = "secure"
+	releaseEraseQuick         = "quick"
+	releaseEraseSecureOrQuick = "secure_or_quick"
+)
+
+var releaseEraseModes = []string{
+	releaseEraseNone,
+	releaseEraseFull,
+	releaseEraseSecure,
+	releaseEraseQuick,
+	releaseEraseSecureOrQuick,
+}
+
+// releaseEraseParams translate a release_erase_mode into the erase parameters of the release operation
+func releaseEraseParams(mode string) url.Values {
+	params := url.Values{}
+
+	switch mode {
+	case releaseEraseNone:
+		params.Set("erase", strconv.FormatBool(false))
+	case releaseEraseSecure:
+		params.Set("erase", strconv.FormatBool(true))
+		params.Set("secure_erase", strconv.FormatBool(true))
+	case releaseEraseQuick:
+		params.Set("erase", strconv.FormatBool(true))
+		params.Set("quick_erase", strconv.FormatBool(true))
+	case releaseEraseSecureOrQuick:
+		// MAAS falls back to a quick erase when the disk doesn't support secure erase
+		params.Set("erase", strconv.FormatBool(true))
+		params.Set("secure_erase", strconv.FormatBool(true))
+		params.Set("quick_erase", strconv.FormatBool(true))
+	default:
+		params.Set("erase", strconv.FormatBool(true))
+	}
+
+	return params
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// placement_policy values
+const (
+	placementSpreadZones = "spread_zones"
+	placementSpreadPools = "spread_pools"
+	placementPack        = "pack"
+)
+
+var placementPolicies = []string{placementSpreadZones, placementSpreadPools, placementPack}
+
+// resourceMAASInstanceGroup creates a new terraform schema resource for a group of deployed nodes
+// placed across zones or pools
+func resourceMAASInstanceGroup() *schema.Resource {
+	log.Println("[DEBUG] [resourceMAASInstanceGroup] Initializing data structure")
+
+	groupSchema := allocationConstraintsSchema()
+
+	// members can't share a hostname, and zone, pool and architecture may differ between members
+	delete(groupSchema, "hostname")
+	for _, name := range []string{"zone", "pool", "architecture"} {
+		groupSchema[name].Computed = false
+	}
+
+	groupSchema["size"] = &schema.Schema{
+		Type:     schema.TypeInt,
+		Required: true,
+		ForceNew: true,
+	}
+
+	groupSchema["placement_policy"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		ForceNew:     true,
+		Default:      placementSpreadZones,
+		ValidateFunc: validateStringInSlice(placementPolicies),
+	}
+
+	groupSchema["placement_targets"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
+	}
+
+	groupSchema["atomic"] = &schema.Schema{
+		Type:     schema.TypeBool,
+		Optional: true,
+		ForceNew: true,
+		Default:  false,
+	}
+
+	groupSchema["distro_series"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+		ForceNew: true,
+	}
+
+	groupSchema["user_data"] = &schema.Schema{
+		Type:      schema.TypeString,
+		Optional:  true,
+		ForceNew:  true,
+		Sensitive: true,
+		StateFunc: userDataStateFunc,
+	}
+
+	groupSchema["comment"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+		ForceNew: true,
+	}
+
+	groupSchema["release_comment"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+	}
+
+	groupSchema["system_ids"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
+	}
+
+	groupSchema["members"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"system_id": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"hostname": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"fqdn": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"zone": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"pool": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"ip_addresses": {
+					Type:     schema.TypeList,
+					Computed: true,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+				},
+			},
+		},
+	}
+
+	groupSchema["placement"] = &schema.Schema{
+		Type:     schema.TypeMap,
+		Computed: true,
+	}
+
+	return &schema.Resource{
+		Create: resourceMAASInstanceGroupCreate,
+		Read:   resourceMAASInstanceGroupRead,
+		Update: resourceMAASInstanceGroupUpdate,
+		Delete: resourceMAASInstanceGroupDelete,
+
+		Schema: groupSchema,
+	}
+}
+
+// groupPlacementParam the allocate constraint a placement policy sets on each member
+func groupPlacementParam(policy string) string {
+	if policy == placementSpreadPools {
+		return "pool"
+	}
+	return "zone"
+}
+
+// groupPlacement compute the zone (or pool) of each member of a group.
+// The spread policies hand out the targets round-robin so no target gets two members more than another.
+// pack puts every member in the first target, or leaves the placement to MAAS when there are no targets.
+func groupPlacement(policy string, size int, targets []string) ([]string, error) {
+	placement := make([]string, size)
+
+	switch policy {
+	case placementSpreadZones, placementSpreadPools:
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("No %ss to spread the group over", groupPlacementParam(policy))
+		}
+		for i := range placement {
+			placement[i] = targets[i%len(targets)]
+		}
+	case placementPack:
+		if len(targets) > 0 {
+			for i := range placement {
+				placement[i] = targets[0]
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unknown placement policy: %s", policy)
+	}
+	return placement, nil
+}
+
+// resourceMAASInstanceGroupTargets the zones or pools a group is placed over, defaulting to every zone or
+// pool MAAS knows about except the excluded ones
+func resourceMAASInstanceGroupTargets(d *schema.ResourceData, meta interface{}) ([]string, error) {
+	policy := d.Get("placement_policy").(string)
+	param := groupPlacementParam(policy)
+
+	if _, ok := d.GetOk(param); ok && policy != placementPack {
+		return nil, fmt.Errorf("%s can't be combined with the %s placement policy, use placement_targets instead", param, policy)
+	}
+
+	targets := []string{}
+	for _, target := range d.Get("placement_targets").([]interface{}) {
+		targets = append(targets, target.(string))
+	}
+	if len(targets) > 0 || policy == placementPack {
+		return targets, nil
+	}
+
+	collection, exclude := "zones", "not_in_zone"
+	if param == "pool" {
+		collection, exclude = "resourcepools", "not_in_pool"
+	}
+
+	names, err := maasListNames(meta.(*Config).MAASObject, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[string]bool{}
+	for _, name := range d.Get(exclude).([]interface{}) {
+		excluded[name.(string)] = true
+	}
+	for _, name := range names {
+		if !excluded[name] {
+			targets = append(targets, name)
+		}
+	}
+	return targets, nil
+}
+
+// resourceMAASInstanceGroupCreate allocate and deploy every member of the group in its computed zone or pool
+func resourceMAASInstanceGroupCreate(d *schema.ResourceData, meta interface{}) error {
+	log.Println("[DEBUG] [resourceMAASInstanceGroupCreate] Launching new maas_instance_group")
+
+	policy := d.Get("placement_policy").(string)
+	param := groupPlacementParam(policy)
+
+	targets, err := resourceMAASInstanceGroupTargets(d, meta)
+	if err != nil {
+		return err
+	}
+
+	placement, err := groupPlacement(policy, d.Get("size").(int), targets)
+	if err != nil {
+		return err
+	}
+
+	constraints := parseAllocationConstraints(d)
+
+	deploy_params := url.Values{}
+	if user_data, ok := d.GetOk("user_data"); ok {
+		deploy_params.Set("user_data", userDataEncode(user_data.(string)))
+	}
+	if comment, ok := d.GetOk("comment"); ok {
+		deploy_params.Set("comment", comment.(string))
+	}
+	if distro_series, ok := d.GetOk("distro_series"); ok {
+		deploy_params.Set("distro_series", distro_series.(string))
+	}
+
+	// all or nothing: allocate every member before the first one is deployed
+	if d.Get("atomic").(bool) {
+		system_ids, err := groupAllocateAll(meta, constraints, param, placement)
+		if err != nil {
+			return err
+		}
+
+		d.SetId(resource.PrefixedUniqueId("maas-group-"))
+		d.Set("system_ids", system_ids)
+
+		for i, system_id := range system_ids {
+			if err := nodeDo(meta.(*Config).MAASObject, system_id, "deploy", deploy_params); err != nil {
+				log.Printf("[ERROR] [resourceMAASInstanceGroupCreate] Unable to deploy member %d (%s)", i, system_id)
+				return err
+			}
+		}
+
+		return resourceMAASInstanceGroupWait(d, meta)
+	}
+
+	d.SetId(resource.PrefixedUniqueId("maas-group-"))
+
+	system_ids := make([]string, 0, len(placement))
+	for i, target := range placement {
+		// pack the members into whichever zone MAAS picked for the first one
+		if policy == placementPack && target == "" && i > 0 {
+			first, err := getSingleNode(meta.(*Config).MAASObject, system_ids[0])
+			if err != nil {
+				return err
+			}
+			target = first.zone
+		}
+
+		nodeObj, err := nodesAllocate(meta.(*Config).MAASObject, groupMemberConstraints(constraints, param, target))
+		if err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceGroupCreate] Unable to allocate member %d", i)
+			return fmt.Errorf("[ERROR] [resourceMAASInstanceGroupCreate] Unable to allocate member %d of the group (%s %q): %s", i, param, target, err)
+		}
+
+		// the group is tracked from the first member on, so a failure part way releases what was allocated
+		system_ids = append(system_ids, nodeObj.system_id)
+		d.Set("system_ids", system_ids)
+
+		if err := nodeDo(meta.(*Config).MAASObject, nodeObj.system_id, "deploy", deploy_params); err != nil {
+			log.Printf("[ERROR] [resourceMAASInstanceGroupCreate] Unable to deploy member %d (%s)", i, nodeObj.system_id)
+			return err
+		}
+	}
+
+	return resourceMAASInstanceGroupWait(d, meta)
+}
+
+// groupMemberConstraints the allocate constraints of a member placed in the given zone or pool
+func groupMemberConstraints(constraints url.Values, param string, target string) url.Values {
+	member_constraints := url.Values{}
+	for key, values := range constraints {
+		member_constraints[key] = values
+	}
+	if target != "" {
+		member_constraints.Set(param, target)
+	}
+	return member_constraints
+}
+
+// groupAllocateAll allocate a machine for every member of the group, releasing the ones already allocated
+// if any allocation fails
+func groupAllocateAll(meta interface{}, constraints url.Values, param string, placement []string) ([]string, error) {
+	maas := meta.(*Config).MAASObject
+
+	system_ids := make([]string, 0, len(placement))
+	for i, target := range placement {
+		// pack the members into whichever zone MAAS picked for the first one
+		if target == "" && i > 0 {
+			if first, err := getSingleNode(maas, system_ids[0]); err == nil {
+				target = first.zone
+			}
+		}
+
+		member_constraints := groupMemberConstraints(constraints, param, target)
+
+		nodeObject, err := maasAllocateNodes(maas, member_constraints)
+		var nodeObj *NodeInfo
+		if err == nil {
+			nodeObj, err = toNodeInfo(&nodeObject)
+		}
+		if err != nil {
+			log.Printf("[ERROR] [groupAllocateAll] Unable to allocate member %d, releasing %d allocated members", i, len(system_ids))
+			for _, system_id := range system_ids {
+				if err := maasReleaseNode(maas, system_id, url.Values{}); err != nil {
+					log.Printf("[ERROR] [groupAllocateAll] Unable to release node (%s): %s", system_id, err)
+				}
+			}
+			return nil, groupCapacityError(member_constraints, param, target, placement, i, err)
+		}
+
+		system_ids = append(system_ids, nodeObj.system_id)
+	}
+	return system_ids, nil
+}
+
+// groupCapacityError describe why member i of a group couldn't be allocated. When MAAS has no matching machine
+// the error names the zone or pool that ran out, or the shared constraints if the member had no placement.
+func groupCapacityError(constraints url.Values, param string, target string, placement []string, i int, err error) error {
+	if !isConflictError(err) {
+		return fmt.Errorf("Unable to allocate member %d of the group: %s", i, err)
+	}
+
+	if target == "" {
+		return fmt.Errorf("Not enough machines matching %v: only %d of %d members could be allocated: %s",
+			constraints, i, len(placement), err)
+	}
+
+	allocated, requested := 0, 0
+	for j, t := range placement {
+		if t != target {
+			continue
+		}
+		requested++
+		if j < i {
+			allocated++
+		}
+	}
+	return fmt.Errorf("%s %q ran out of capacity: only %d of the %d members placed there could be allocated (constraints %v): %s",
+		param, target, allocated, requested, constraints, err)
+}
+
+// resourceMAASInstanceGroupWait wait for every deployed member of the group to become active
+func resourceMAASInstanceGroupWait(d *schema.ResourceData, meta interface{}) error {
+	for _, v := range d.Get("system_ids").([]interface{}) {
+		system_id := v.(string)
+		log.Printf("[DEBUG] [resourceMAASInstanceGroupWait] Waiting for member (%s) to become active\n", system_id)
+		stateConf := &resource.StateChangeConf{
+			Pending:    []string{"9:"},
+			Target:     []string{"6:"},
+			Refresh:    getNodeStatus(meta.(*Config).MAASObject, system_id),
+			Timeout:    25 * time.Minute,
+			Delay:      10 * time.Second,
+			MinTimeout: 3 * time.Second,
+		}
+
+		if _, err := stateConf.WaitForState(); err != nil {
+			return fmt.Errorf(
+				"[ERROR] [resourceMAASInstanceGroupWait] Error waiting for member (%s) to become deployed: %s", system_id, err)
+		}
+	}
+
+	return resourceMAASInstanceGroupRead(d, meta)
+}
+
+// resourceMAASInstanceGroupRead read the members of the group and where they were placed
+func resourceMAASInstanceGroupRead(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] [resourceMAASInstanceGroupRead] Reading group (%s) information.\n", d.Id())
+
+	param := groupPlacementParam(d.Get("placement_policy").(string))
+
+	system_ids := []string{}
+	members := []map[string]interface{}{}
+	placement := map[string]interface{}{}
+
+	for _, v := range d.Get("system_ids").([]interface{}) {
+		system_id := v.(string)
+
+		nodeObj, err := getSingleNode(meta.(*Config).MAASObject, system_id)
+		if err != nil {
+			if isNotFoundError(err) {
+				log.Printf("[WARN] [resourceMAASInstanceGroupRead] Member (%s) no longer exists", system_id)
+				continue
+			}
+			return err
+		}
+
+		system_ids = append(system_ids, system_id)
+		members = append(members, map[string]interface{}{
+			"system_id":    nodeObj.system_id,
+			"hostname":     nodeObj.hostname,
+			"fqdn":         nodeObj.fqdn,
+			"zone":         nodeObj.zone,
+			"pool":         nodeObj.pool,
+			"ip_addresses": nodeObj.ip_addresses,
+		})
+
+		if param == "pool" {
+			placement[nodeObj.hostname] = nodeObj.pool
+		} else {
+			placement[nodeObj.hostname] = nodeObj.zone
+		}
+	}
+
+	if len(system_ids) == 0 {
+		log.Printf("[WARN] [resourceMAASInstanceGroupRead] Group (%s) has no members left", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	if err := d.Set("system_ids", system_ids); err != nil {
+		return err
+	}
+	if err := d.Set("members", members); err != nil {
+		return err
+	}
+	if err := d.Set("placement", placement); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// resourceMAASInstanceGroupUpdate only release options can change without replacing the group
+func resourceMAASInstanceGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+	return resourceMAASInstanceGroupRead(d, meta)
+}
+
+// resourceMAASInstanceGroupDelete release every member of the group
+func resourceMAASInstanceGroupDelete(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] [resourceMAASInstanceGroupDelete] Releasing group %s\n", d.Id())
+
+	release_params := url.Values{}
+	if comment, ok := d.GetOk("release_comment"); ok {
+		release_params.Set("comment", comment.(string))
+	}
+
+	system_ids := []string{}
+	for _, v := range d.Get("system_ids").([]interface{}) {
+		system_id := v.(string)
+		if err := nodeRelease(meta.(*Config).MAASObject, system_id, release_params); err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return err
+		}
+		system_ids = append(system_ids, system_id)
+	}
+
+	for _, system_id := range system_ids {
+		stateConf := &resource.StateChangeConf{
+			Pending:    []string{"6:", "9:", "10:", "11:", "12:", "14:"},
+			Target:     []string{"4:"},
+			Refresh:    getNodeStatus(meta.(*Config).MAASObject, system_id),
+			Timeout:    30 * time.Minute,
+			Delay:      10 * time.Second,
+			MinTimeout: 3 * time.Second,
+		}
+
+		if _, err := stateConf.WaitForState(); err != nil {
+			return fmt.Errorf(
+				"[ERROR] [resourceMAASInstanceGroupDelete] Error waiting for member (%s) to become ready: %s", system_id, err)
+		}
+	}
+
+	d.SetId("")
+
+	return nil
+}
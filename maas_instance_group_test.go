@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juju/gomaasapi"
+)
+
+func TestGroupPlacement(t *testing.T) {
+	cases := []struct {
+		policy   string
+		size     int
+		targets  []string
+		expected []string
+	}{
+		{placementSpreadZones, 5, []string{"a", "b", "c"}, []string{"a", "b", "c", "a", "b"}},
+		{placementSpreadPools, 2, []string{"gold", "silver", "bronze"}, []string{"gold", "silver"}},
+		{placementPack, 3, []string{"a", "b"}, []string{"a", "a", "a"}},
+		{placementPack, 2, nil, []string{"", ""}},
+	}
+
+	for _, c := range cases {
+		placement, err := groupPlacement(c.policy, c.size, c.targets)
+		if err != nil {
+			t.Fatalf("%s %v: %s", c.policy, c.targets, err)
+		}
+		if !reflect.DeepEqual(placement, c.expected) {
+			t.Fatalf("%s %v: got %v, expected %v", c.policy, c.targets, placement, c.expected)
+		}
+	}
+
+	if _, err := groupPlacement(placementSpreadZones, 3, nil); err == nil {
+		t.Fatal("spreading over no zones should fail")
+	}
+}
+
+func TestGroupAllocateAllReleasesPartialSet(t *testing.T) {
+	allocated := 0
+	released := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("op") {
+		case "allocate":
+			if r.Form.Get("zone") == "zone-b" {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, "No available machine matches constraints")
+				return
+			}
+			allocated++
+			fmt.Fprintf(w, `{"system_id": "node-%d", "resource_uri": "/MAAS/api/2.0/machines/node-%d/"}`, allocated, allocated)
+		case "release":
+			released = append(released, strings.Split(r.URL.Path, "/")[5])
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := gomaasapi.NewAnonymousClient(server.URL+"/MAAS/", "2.0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	meta := &Config{MAASObject: gomaasapi.NewMAAS(*client)}
+
+	placement := []string{"zone-a", "zone-b", "zone-a", "zone-b"}
+	_, err = groupAllocateAll(meta, url.Values{"tags": {"etcd"}}, "zone", placement)
+	if err == nil {
+		t.Fatal("expected the allocation to fail")
+	}
+	if !strings.Contains(err.Error(), `zone "zone-b" ran out of capacity: only 0 of the 2 members`) {
+		t.Fatalf("bad error: %s", err)
+	}
+	if !reflect.DeepEqual(released, []string{"node-1"}) {
+		t.Fatalf("expected the allocated member to be released, got %v", released)
+	}
+}
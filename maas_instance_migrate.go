@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// maasInstanceStateUpgraders upgrade a maas_instance state by one schema version: upgrader v takes a version v
+// state to version v+1. The schema version of the resource is the number of upgraders.
+var maasInstanceStateUpgraders = []func(*terraform.InstanceState) (*terraform.InstanceState, error){
+	migrateMAASInstanceStateV0toV1,
+	migrateMAASInstanceStateV1toV2,
+	migrateMAASInstanceStateV2toV3,
+	migrateMAASInstanceStateV3toV4,
+	migrateMAASInstanceStateV4toV5,
+	migrateMAASInstanceStateV5toV6,
+	migrateMAASInstanceStateV6toV7,
+	migrateMAASInstanceStateV7toV8,
+}
+
+// resourceMAASInstanceMigrateState upgrade a maas_instance state written by an older schema version
+func resourceMAASInstanceMigrateState(v int, is *terraform.InstanceState, meta interface{}) (*terraform.InstanceState, error) {
+	if v < 0 || v >= len(maasInstanceStateUpgraders) {
+		return is, fmt.Errorf("Unexpected schema version: %d", v)
+	}
+
+	if is.Empty() {
+		log.Println("[DEBUG] [resourceMAASInstanceMigrateState] Empty InstanceState; nothing to migrate.")
+		return is, nil
+	}
+
+	for ; v < len(maasInstanceStateUpgraders); v++ {
+		log.Printf("[INFO] [resourceMAASInstanceMigrateState] Found MAAS Instance State v%d; migrating to v%d", v, v+1)
+		log.Printf("[DEBUG] [resourceMAASInstanceMigrateState] Attributes before migration: %#v", is.Attributes)
+
+		var err error
+		if is, err = maasInstanceStateUpgraders[v](is); err != nil {
+			return is, err
+		}
+	}
+
+	log.Printf("[DEBUG] [resourceMAASInstanceMigrateState] Attributes after migration: %#v", is.Attributes)
+	return is, nil
+}
+
+// migrateMAASInstanceStateV0toV1 version 0 and 1 states have the same attributes
+func migrateMAASInstanceStateV0toV1(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	return is, nil
+}
+
+// migrateMAASInstanceStateV1toV2 replace the release_erase* booleans with release_erase_mode
+func migrateMAASInstanceStateV1toV2(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	erase := is.Attributes["release_erase"] != "false"
+	secure := is.Attributes["release_erase_secure"] == "true"
+	quick := is.Attributes["release_erase_quick"] == "true"
+
+	mode := releaseEraseNone
+	switch {
+	case secure && quick:
+		mode = releaseEraseSecureOrQuick
+	case secure:
+		mode = releaseEraseSecure
+	case quick:
+		mode = releaseEraseQuick
+	case erase:
+		mode = releaseEraseFull
+	}
+
+	delete(is.Attributes, "release_erase")
+	delete(is.Attributes, "release_erase_secure")
+	delete(is.Attributes, "release_erase_quick")
+	is.Attributes["release_erase_mode"] = mode
+
+	if _, ok := is.Attributes["wait_for_release"]; !ok {
+		is.Attributes["wait_for_release"] = "true"
+	}
+	return is, nil
+}
+
+// migrateMAASInstanceStateV2toV3 nodes created before release_on_destroy existed are always released
+func migrateMAASInstanceStateV2toV3(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	if _, ok := is.Attributes["release_on_destroy"]; !ok {
+		is.Attributes["release_on_destroy"] = "true"
+	}
+	return is, nil
+}
+
+// migrateMAASInstanceStateV3toV4 make cpu_count and memory whole numbers. States written while the constraints were
+// handled as strings may hold values such as "8.0" or " 4096" that can't be read as integers.
+func migrateMAASInstanceStateV3toV4(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	for _, name := range []string{"cpu_count", "memory"} {
+		coerceStateInt(is.Attributes, name)
+	}
+	return is, nil
+}
+
+// migrateMAASInstanceStateV4toV5 the tag attributes became sets, so their order no longer matters
+func migrateMAASInstanceStateV4toV5(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	for _, name := range []string{"tags", "deploy_tags", "original_tags", "tag_names"} {
+		migrateStateListToSet(is.Attributes, name)
+	}
+	return is, nil
+}
+
+// migrateMAASInstanceStateV5toV6 sticky_machine briefly held the key the machine is handed over under,
+// a boolean is kept as it is and converted back by the next upgrader
+func migrateMAASInstanceStateV5toV6(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	return is, nil
+}
+
+// migrateMAASInstanceStateV6toV7 sticky_machine is a boolean again, set for any key that was given
+func migrateMAASInstanceStateV6toV7(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	value, ok := is.Attributes["sticky_machine"]
+	if !ok {
+		return is, nil
+	}
+
+	switch value {
+	case "", "false", "0":
+		delete(is.Attributes, "sticky_machine")
+	default:
+		is.Attributes["sticky_machine"] = "true"
+	}
+	return is, nil
+}
+
+// migrateMAASInstanceStateV7toV8 zone and pxe_mac used to be sets of blocks and are now strings read from MAAS,
+// so whatever is left of the old sets is dropped and the next refresh fills them in
+func migrateMAASInstanceStateV7toV8(is *terraform.InstanceState) (*terraform.InstanceState, error) {
+	for _, name := range []string{"zone", "pxe_mac"} {
+		dropStateNestedKeys(is.Attributes, name)
+	}
+	return is, nil
+}
+
+// coerceStateInt rewrite a numeric state attribute as an integer, dropping it if it isn't a number at all
+func coerceStateInt(attributes map[string]string, name string) {
+	value, ok := attributes[name]
+	if !ok {
+		return
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		log.Printf("[WARN] [coerceStateInt] Dropping %s: %q is not a number", name, value)
+		delete(attributes, name)
+		return
+	}
+	attributes[name] = strconv.Itoa(int(number))
+}
+
+// migrateStateListToSet rewrite a list of strings in the state as a set of strings,
+// ie: tags.# = 2, tags.0 = a, tags.1 = b becomes tags.# = 2, tags.<hash a> = a, tags.<hash b> = b
+func migrateStateListToSet(attributes map[string]string, name string) {
+	count, ok := attributes[name+".#"]
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return
+	}
+
+	values := map[string]bool{}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("%s.%d", name, i)
+		if value, ok := attributes[key]; ok {
+			values[value] = true
+			delete(attributes, key)
+		}
+	}
+
+	for value := range values {
+		attributes[fmt.Sprintf("%s.%d", name, schema.HashString(value))] = value
+	}
+	attributes[name+".#"] = strconv.Itoa(len(values))
+}
+
+// dropStateNestedKeys remove the keys of a list, set or map attribute from the state, keeping a plain value of the same name
+func dropStateNestedKeys(attributes map[string]string, name string) {
+	for key := range attributes {
+		if strings.HasPrefix(key, name+".") {
+			delete(attributes, key)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestMAASInstanceMigrateStateV1toV2(t *testing.T) {
+	cases := map[string]struct {
+		Attributes map[string]string
+		Expected   string
+	}{
+		"default": {
+			Attributes: map[string]string{"release_erase": "true"},
+			Expected:   "full",
+		},
+		"missing": {
+			Attributes: map[string]string{"hostname": "node-1"},
+			Expected:   "full",
+		},
+		"disabled": {
+			Attributes: map[string]string{"release_erase": "false"},
+			Expected:   "none",
+		},
+		"secure": {
+			Attributes: map[string]string{"release_erase": "false", "release_erase_secure": "true"},
+			Expected:   "secure",
+		},
+		"quick": {
+			Attributes: map[string]string{"release_erase": "true", "release_erase_quick": "true"},
+			Expected:   "quick",
+		},
+		"secure and quick": {
+			Attributes: map[string]string{"release_erase_secure": "true", "release_erase_quick": "true"},
+			Expected:   "secure_or_quick",
+		},
+	}
+
+	for name, tc := range cases {
+		is := &terraform.InstanceState{ID: "abc123", Attributes: tc.Attributes}
+		is, err := resourceMAASInstanceMigrateState(1, is, nil)
+		if err != nil {
+			t.Fatalf("%s: bad: %s", name, err)
+		}
+
+		if v := is.Attributes["release_erase_mode"]; v != tc.Expected {
+			t.Fatalf("%s: release_erase_mode is %q, expected %q", name, v, tc.Expected)
+		}
+		for _, k := range []string{"release_erase", "release_erase_secure", "release_erase_quick"} {
+			if _, ok := is.Attributes[k]; ok {
+				t.Fatalf("%s: %s should have been removed", name, k)
+			}
+		}
+		if is.Attributes["wait_for_release"] != "true" {
+			t.Fatalf("%s: wait_for_release should default to true", name)
+		}
+	}
+}
+
+func TestMAASInstanceMigrateStateV2toV3(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{"release_erase_mode": "quick"}}
+	is, err := resourceMAASInstanceMigrateState(2, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if is.Attributes["release_on_destroy"] != "true" {
+		t.Fatalf("release_on_destroy should default to true, got %q", is.Attributes["release_on_destroy"])
+	}
+	if is.Attributes["release_erase_mode"] != "quick" {
+		t.Fatalf("release_erase_mode should be untouched, got %q", is.Attributes["release_erase_mode"])
+	}
+}
+
+func TestMAASInstanceMigrateStateVersions(t *testing.T) {
+	if v := resourceMAASInstance().SchemaVersion; v != len(maasInstanceStateUpgraders) {
+		t.Fatalf("SchemaVersion is %d but there are upgraders for %d versions", v, len(maasInstanceStateUpgraders))
+	}
+
+	for _, v := range []int{-1, len(maasInstanceStateUpgraders)} {
+		is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{}}
+		if _, err := resourceMAASInstanceMigrateState(v, is, nil); err == nil {
+			t.Fatalf("version %d should be rejected", v)
+		}
+	}
+}
+
+func TestMAASInstanceMigrateStateV0(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{
+		"release_erase_secure": "true",
+		"cpu_count":            "8",
+		"memory":               "16384",
+	}}
+	is, err := resourceMAASInstanceMigrateState(0, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	expected := map[string]string{
+		"release_erase_mode": "secure",
+		"wait_for_release":   "true",
+		"release_on_destroy": "true",
+		"cpu_count":          "8",
+		"memory":             "16384",
+	}
+	for k, v := range expected {
+		if is.Attributes[k] != v {
+			t.Fatalf("%s is %q, expected %q", k, is.Attributes[k], v)
+		}
+	}
+}
+
+func TestMAASInstanceMigrateStateV3toV4(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{
+		"cpu_count": "8.0",
+		"memory":    " 4096",
+		"hostname":  "node-1",
+	}}
+	is, err := resourceMAASInstanceMigrateState(3, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if is.Attributes["cpu_count"] != "8" || is.Attributes["memory"] != "4096" || is.Attributes["hostname"] != "node-1" {
+		t.Fatalf("bad attributes: %#v", is.Attributes)
+	}
+
+	is = &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{"memory": "lots"}}
+	is, err = resourceMAASInstanceMigrateState(3, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if _, ok := is.Attributes["memory"]; ok {
+		t.Fatalf("memory should have been dropped: %#v", is.Attributes)
+	}
+}
+
+func TestMigrateStateListToSet(t *testing.T) {
+	attributes := map[string]string{
+		"tags.#":   "3",
+		"tags.0":   "ssd",
+		"tags.1":   "10g",
+		"tags.2":   "ssd",
+		"hostname": "node-1",
+	}
+	migrateStateListToSet(attributes, "tags")
+
+	expected := map[string]string{
+		"tags.#": "2",
+		fmt.Sprintf("tags.%d", schema.HashString("ssd")): "ssd",
+		fmt.Sprintf("tags.%d", schema.HashString("10g")): "10g",
+		"hostname": "node-1",
+	}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Fatalf("got %#v, expected %#v", attributes, expected)
+	}
+
+	// missing lists are left alone
+	attributes = map[string]string{"hostname": "node-1"}
+	migrateStateListToSet(attributes, "deploy_tags")
+	if len(attributes) != 1 {
+		t.Fatalf("bad attributes: %#v", attributes)
+	}
+}
+
+func TestMAASInstanceMigrateStateV4toV5(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{
+		"tags.#":        "2",
+		"tags.0":        "ssd",
+		"tags.1":        "10g",
+		"deploy_tags.#": "1",
+		"deploy_tags.0": "terraform",
+	}}
+	is, err := resourceMAASInstanceMigrateState(4, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	d := resourceMAASInstance().Data(is)
+	tags := d.Get("tags").(*schema.Set)
+	if tags.Len() != 2 || !tags.Contains("ssd") || !tags.Contains("10g") {
+		t.Fatalf("bad tags: %v", tags.List())
+	}
+	if deploy_tags := d.Get("deploy_tags").(*schema.Set); deploy_tags.Len() != 1 || !deploy_tags.Contains("terraform") {
+		t.Fatalf("bad deploy_tags: %v", deploy_tags.List())
+	}
+}
+
+func TestMAASInstanceTagsOrderInsensitive(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{
+		"tags.#":        "2",
+		"tags.0":        "ssd",
+		"tags.1":        "10g",
+		"deploy_tags.#": "2",
+		"deploy_tags.0": "web",
+		"deploy_tags.1": "terraform",
+	}}
+	is, err := resourceMAASInstanceMigrateState(4, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	is.Attributes = resourceMAASInstance().Data(is).State().Attributes
+
+	raw, err := config.NewRawConfig(map[string]interface{}{
+		"tags":        []interface{}{"10g", "ssd"},
+		"deploy_tags": []interface{}{"terraform", "web"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	diff, err := resourceMAASInstance().Diff(is, terraform.NewResourceConfig(raw))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, name := range []string{"tags", "deploy_tags"} {
+		for k, attr := range diff.Attributes {
+			if strings.HasPrefix(k, name+".") && (attr.Old != attr.New || attr.NewRemoved || attr.NewComputed) {
+				t.Fatalf("reordering %s should not produce a diff: %#v", name, diff.Attributes)
+			}
+		}
+	}
+}
+
+func TestMAASInstanceMigrateStateV5toV6(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{"sticky_machine": "true"}}
+	is, err := resourceMAASInstanceMigrateState(5, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if is.Attributes["sticky_machine"] != "true" {
+		t.Fatalf("sticky_machine should have been kept: %#v", is.Attributes)
+	}
+}
+
+func TestMAASInstanceMigrateStateV6toV7(t *testing.T) {
+	cases := map[string]string{
+		"storage-01": "true",
+		"true":       "true",
+		"false":      "",
+		"":           "",
+	}
+	for value, expected := range cases {
+		is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{"sticky_machine": value}}
+		is, err := resourceMAASInstanceMigrateState(6, is, nil)
+		if err != nil {
+			t.Fatalf("bad: %s", err)
+		}
+		if is.Attributes["sticky_machine"] != expected {
+			t.Fatalf("%q: got %#v, expected %q", value, is.Attributes, expected)
+		}
+	}
+}
+
+func TestMAASInstanceMigrateStateV7toV8(t *testing.T) {
+	is := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{
+		"zone.#":                       "1",
+		"zone.1234567.name":            "default",
+		"zone.1234567.description":     "",
+		"zone.1234567.resource_uri":    "/MAAS/api/2.0/zones/default/",
+		"pxe_mac.#":                    "1",
+		"pxe_mac.7654321.mac_address":  "52:54:00:12:34:56",
+		"pxe_mac.7654321.resource_uri": "/MAAS/api/2.0/nodes/abc123/macs/52:54:00:12:34:56/",
+		"pool":                         "default",
+	}}
+	is, err := resourceMAASInstanceMigrateState(7, is, nil)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	expected := map[string]string{"pool": "default"}
+	if !reflect.DeepEqual(is.Attributes, expected) {
+		t.Fatalf("bad attributes: %#v", is.Attributes)
+	}
+
+	// a state written since keeps its plain values
+	is = &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{"zone": "default", "pxe_mac": "52:54:00:12:34:56"}}
+	if is, err = resourceMAASInstanceMigrateState(7, is, nil); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if is.Attributes["zone"] != "default" || is.Attributes["pxe_mac"] != "52:54:00:12:34:56" {
+		t.Fatalf("bad attributes: %#v", is.Attributes)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/juju/gomaasapi"
+)
+
+func TestResourceMAASInstanceCreateClearsIdOnDeployError(t *testing.T) {
+	released := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case r.Form.Get("op") == "allocate":
+			fmt.Fprint(w, `{"system_id": "node-1", "hostname": "node-1", "resource_uri": "/MAAS/api/2.0/nodes/node-1/"}`)
+		case r.Form.Get("op") == "deploy":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "No boot images are available")
+		case r.Form.Get("op") == "release":
+			released = append(released, strings.Split(r.URL.Path, "/")[5])
+			fmt.Fprint(w, `{}`)
+		case r.Method == "GET":
+			fmt.Fprint(w, `{"system_id": "node-1", "hostname": "node-1", "resource_uri": "/MAAS/api/2.0/nodes/node-1/"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := gomaasapi.NewAnonymousClient(server.URL+"/MAAS/", "2.0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	meta := &Config{MAASObject: gomaasapi.NewMAAS(*client)}
+
+	d := schema.TestResourceDataRaw(t, resourceMAASInstance().Schema, map[string]interface{}{
+		"deploy_retries": 2,
+	})
+
+	err = resourceMAASInstanceCreate(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "No boot images are available") {
+		t.Fatalf("expected the deploy error, got %v", err)
+	}
+	if d.Id() != "" {
+		t.Fatalf("the released node (%s) should not be recorded in the state", d.Id())
+	}
+	if !reflect.DeepEqual(released, []string{"node-1"}) {
+		t.Fatalf("expected the node to be released once, got %v", released)
+	}
+}
+
+func TestResourceMAASInstanceReadPowerStateDrift(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"system_id": "node-1", "hostname": "node-1", "power_state": "off", "status": 6, "resource_uri": "/MAAS/api/2.0/nodes/node-1/"}`)
+	}))
+	defer server.Close()
+
+	client, err := gomaasapi.NewAnonymousClient(server.URL+"/MAAS/", "2.0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	meta := &Config{MAASObject: gomaasapi.NewMAAS(*client)}
+
+	d := schema.TestResourceDataRaw(t, resourceMAASInstance().Schema, map[string]interface{}{
+		"desired_power_state": "on",
+	})
+	d.SetId("node-1")
+
+	if err := resourceMAASInstanceRead(d, meta); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if state := d.Get("desired_power_state").(string); state != "off" {
+		t.Fatalf("the observed power state should be read back, got %q", state)
+	}
+	if state := d.Get("power_state").(string); state != "off" {
+		t.Fatalf("bad power_state: %q", state)
+	}
+}
+
+func TestResourceMAASInstanceUpdateRelocksAroundActions(t *testing.T) {
+	for _, queryFails := range []bool{false, true} {
+		ops := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			op := r.Form.Get("op")
+			if op != "" {
+				ops = append(ops, op)
+			}
+			switch {
+			case op == "query_power_state" && queryFails:
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, "Unable to query the BMC")
+			case op == "query_power_state":
+				fmt.Fprint(w, `{"state": "off"}`)
+			case op == "lock" || op == "unlock" || r.Method == "GET":
+				fmt.Fprint(w, `{"system_id": "node-1", "hostname": "node-1", "power_state": "off", "locked": true, "status": 6, "resource_uri": "/MAAS/api/2.0/nodes/node-1/"}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		client, err := gomaasapi.NewAnonymousClient(server.URL+"/MAAS/", "2.0")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		meta := &Config{MAASObject: gomaasapi.NewMAAS(*client)}
+
+		// the node stays locked while it is powered off
+		deployed := schema.TestResourceDataRaw(t, resourceMAASInstance().Schema, map[string]interface{}{
+			"locked":              true,
+			"desired_power_state": "on",
+		})
+		deployed.SetId("node-1")
+		state := deployed.State()
+
+		raw, err := config.NewRawConfig(map[string]interface{}{
+			"locked":              true,
+			"desired_power_state": "off",
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		diff, err := resourceMAASInstance().Diff(state, terraform.NewResourceConfig(raw))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		_, err = resourceMAASInstance().Apply(state, diff, meta)
+		server.Close()
+
+		if queryFails && err == nil {
+			t.Fatal("expected the failed action to fail the update")
+		}
+		if !queryFails && err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if expected := []string{"unlock", "query_power_state", "lock"}; !reflect.DeepEqual(ops, expected) {
+			t.Fatalf("query fails %t: got %v, expected %v", queryFails, ops, expected)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// resourceMAASMachineAllocation creates a new terraform schema resource for an allocated but not deployed machine
+func resourceMAASMachineAllocation() *schema.Resource {
+	log.Println("[DEBUG] [resourceMAASMachineAllocation] Initializing data structure")
+
+	machineSchema := allocationConstraintsSchema()
+
+	machineSchema["comment"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+		ForceNew: true,
+	}
+
+	machineSchema["release_comment"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+	}
+
+	for _, name := range []string{"system_id", "fqdn", "owner", "pxe_mac", "power_state"} {
+		machineSchema[name] = &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		}
+	}
+
+	for _, name := range []string{"cores", "memory_mb", "status"} {
+		machineSchema[name] = &schema.Schema{
+			Type:     schema.TypeInt,
+			Computed: true,
+		}
+	}
+
+	for _, name := range []string{"ip_addresses", "tag_names"} {
+		machineSchema[name] = &schema.Schema{
+			Type:     schema.TypeList,
+			Computed: true,
+			Elem:     &schema.Schema{Type: schema.TypeString},
+		}
+	}
+
+	instanceSchema := resourceMAASInstance().Schema
+	// interfaces is already the allocation constraint, so the machine's interfaces are exported under another name
+	machineSchema["machine_interfaces"] = instanceSchema["interfaces"]
+	machineSchema["physicalblockdevice_set"] = instanceSchema["physicalblockdevice_set"]
+
+	return &schema.Resource{
+		Create: resourceMAASMachineAllocationCreate,
+		Read:   resourceMAASMachineAllocationRead,
+		Update: resourceMAASMachineAllocationUpdate,
+		Delete: resourceMAASMachineAllocationDelete,
+
+		Schema: machineSchema,
+	}
+}
+
+// resourceMAASMachineAllocationCreate allocate a machine matching the constraints without deploying it
+func resourceMAASMachineAllocationCreate(d *schema.ResourceData, meta interface{}) error {
+	log.Println("[DEBUG] [resourceMAASMachineAllocationCreate] Allocating new maas_machine_allocation")
+
+	constraints := parseAllocationConstraints(d)
+	if comment, ok := d.GetOk("comment"); ok {
+		constraints.Set("comment", comment.(string))
+	}
+
+	nodeObj, err := nodesAllocate(meta.(*Config).MAASObject, constraints)
+	if err != nil {
+		log.Println("[ERROR] [resourceMAASMachineAllocationCreate] Unable to allocate a node")
+		return err
+	}
+
+	d.SetId(nodeObj.system_id)
+
+	return resourceMAASMachineAllocationRead(d, meta)
+}
+
+// resourceMAASMachineAllocationRead read the allocated machine's details
+func resourceMAASMachineAllocationRead(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] [resourceMAASMachineAllocationRead] Reading allocation (%s) information.\n", d.Id())
+
+	nodeObj, err := getSingleNode(meta.(*Config).MAASObject, d.Id())
+	if err != nil {
+		if isNotFoundError(err) {
+			log.Printf("[WARN] [resourceMAASMachineAllocationRead] Node (%s) no longer exists", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	// the allocation is gone once the machine is back in the pool
+	if nodeObj.status == nodeStatusReady {
+		log.Printf("[WARN] [resourceMAASMachineAllocationRead] Node (%s) is no longer allocated", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("system_id", nodeObj.system_id)
+	d.Set("hostname", nodeObj.hostname)
+	d.Set("fqdn", nodeObj.fqdn)
+	d.Set("architecture", nodeObj.architecture)
+	d.Set("zone", nodeObj.zone)
+	d.Set("pool", nodeObj.pool)
+	d.Set("owner", nodeObj.owner)
+	d.Set("pxe_mac", nodeObj.boot_mac)
+	d.Set("power_state", nodeObj.power_state)
+	d.Set("cores", int(nodeObj.cpu_count))
+	d.Set("memory_mb", int(nodeObj.memory))
+	d.Set("status", int(nodeObj.status))
+
+	if err := d.Set("ip_addresses", nodeObj.ip_addresses); err != nil {
+		return err
+	}
+	if err := d.Set("tag_names", nodeObj.tag_names); err != nil {
+		return err
+	}
+	if err := d.Set("machine_interfaces", flattenNodeInterfaces(nodeObj.interfaces)); err != nil {
+		return err
+	}
+	if err := d.Set("physicalblockdevice_set", flattenNodeBlockDevices(nodeObj.block_devices)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// resourceMAASMachineAllocationUpdate only release options can change without a new allocation
+func resourceMAASMachineAllocationUpdate(d *schema.ResourceData, meta interface{}) error {
+	return resourceMAASMachineAllocationRead(d, meta)
+}
+
+// resourceMAASMachineAllocationDelete release the allocated machine back into the pool
+func resourceMAASMachineAllocationDelete(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] [resourceMAASMachineAllocationDelete] Releasing allocation %s\n", d.Id())
+
+	release_params := url.Values{}
+	if comment, ok := d.GetOk("release_comment"); ok {
+		release_params.Set("comment", comment.(string))
+	}
+
+	if err := nodeRelease(meta.(*Config).MAASObject, d.Id(), release_params); err != nil {
+		return err
+	}
+
+	stateConf := &resource.StateChangeConf{
+		Pending:    []string{"6:", "9:", "10:", "11:", "12:", "14:"},
+		Target:     []string{"4:"},
+		Refresh:    getNodeStatus(meta.(*Config).MAASObject, d.Id()),
+		Timeout:    30 * time.Minute,
+		Delay:      10 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	if _, err := stateConf.WaitForState(); err != nil {
+		return fmt.Errorf(
+			"[ERROR] [resourceMAASMachineAllocationDelete] Error waiting for node (%s) to become ready: %s", d.Id(), err)
+	}
+
+	d.SetId("")
+
+	return nil
+}
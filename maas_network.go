@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/juju/gomaasapi"
+)
+
+// network_interface link modes
+const (
+	linkModeAuto   = "auto"
+	linkModeDHCP   = "dhcp"
+	linkModeStatic = "static"
+	linkModeLinkUp = "link_up"
+)
+
+var linkModes = []string{linkModeAuto, linkModeDHCP, linkModeStatic, linkModeLinkUp}
+
+// linkModeParams the link_subnet mode MAAS expects for each network_interface link mode
+var linkModeParams = map[string]string{
+	linkModeAuto:   "AUTO",
+	linkModeDHCP:   "DHCP",
+	linkModeStatic: "STATIC",
+	linkModeLinkUp: "LINK_UP",
+}
+
+// NetworkInterfaceConfig the requested link configuration of one of a machine's interfaces
+type NetworkInterfaceConfig struct {
+	name            string
+	mode            string
+	subnet          string
+	ip_address      string
+	default_gateway bool
+}
+
+// toNetworkInterfaceConfigs Convenience function to convert the network_interface blocks of a resource
+func toNetworkInterfaceConfigs(d *schema.ResourceData) []NetworkInterfaceConfig {
+	interfaceList := d.Get("network_interface").([]interface{})
+
+	configs := make([]NetworkInterfaceConfig, 0, len(interfaceList))
+	for _, v := range interfaceList {
+		interfaceMap := v.(map[string]interface{})
+		configs = append(configs, NetworkInterfaceConfig{
+			name:            interfaceMap["name"].(string),
+			mode:            interfaceMap["mode"].(string),
+			subnet:          interfaceMap["subnet"].(string),
+			ip_address:      interfaceMap["ip_address"].(string),
+			default_gateway: interfaceMap["default_gateway"].(bool),
+		})
+	}
+	return configs
+}
+
+// validateNetworkInterfaceConfigs make sure each link only uses the settings MAAS accepts for its mode,
+// before a node is allocated
+func validateNetworkInterfaceConfigs(configs []NetworkInterfaceConfig) error {
+	for _, config := range configs {
+		if config.subnet == "" && config.mode != linkModeLinkUp {
+			return fmt.Errorf("Interface %s needs a subnet in %s mode", config.name, config.mode)
+		}
+		if config.ip_address != "" && config.mode != linkModeStatic {
+			return fmt.Errorf("Interface %s can only be given an ip_address in %s mode", config.name, linkModeStatic)
+		}
+		if config.default_gateway && config.mode != linkModeAuto && config.mode != linkModeStatic {
+			return fmt.Errorf("Interface %s can only be the default_gateway in %s or %s mode", config.name, linkModeAuto, linkModeStatic)
+		}
+	}
+	return nil
+}
+
+// linkSubnetParams the link_subnet parameters of an interface link, given the ID of its subnet or 0 if it has none.
+// A link_up link without a subnet only brings the interface up.
+func linkSubnetParams(config NetworkInterfaceConfig, subnet_id int) url.Values {
+	params := url.Values{}
+	params.Set("mode", linkModeParams[config.mode])
+	if subnet_id != 0 {
+		params.Set("subnet", strconv.Itoa(subnet_id))
+	}
+	if config.mode == linkModeStatic && config.ip_address != "" {
+		params.Set("ip_address", config.ip_address)
+	}
+	if config.default_gateway {
+		params.Set("default_gateway", strconv.FormatBool(true))
+	}
+	return params
+}
+
+// controllerGetMachine get the gomaasapi Machine for a single node
+func controllerGetMachine(controller gomaasapi.Controller, system_id string) (gomaasapi.Machine, error) {
+	log.Printf("[DEBUG] [controllerGetMachine] Getting machine (%s) from MAAS\n", system_id)
+
+	machines, err := controller.Machines(gomaasapi.MachinesArgs{SystemIDs: []string{system_id}})
+	if err != nil {
+		log.Printf("[ERROR] [controllerGetMachine] Unable to get machine (%s) from MAAS\n", system_id)
+		return nil, err
+	}
+	if len(machines) != 1 {
+		return nil, fmt.Errorf("Expected one machine with system_id %s, found %d", system_id, len(machines))
+	}
+	return machines[0], nil
+}
+
+// controllerFindSubnet find a subnet by its name or CIDR
+func controllerFindSubnet(controller gomaasapi.Controller, subnet string) (gomaasapi.Subnet, error) {
+	spaces, err := controller.Spaces()
+	if err != nil {
+		log.Println("[ERROR] [controllerFindSubnet] Unable to get spaces")
+		return nil, err
+	}
+
+	for _, space := range spaces {
+		for _, s := range space.Subnets() {
+			if s.CIDR() == subnet || s.Name() == subnet {
+				return s, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Unable to find subnet %s", subnet)
+}
+
+// machineInterface find a machine's interface by name
+func machineInterface(machine gomaasapi.Machine, name string) (gomaasapi.Interface, error) {
+	for _, iface := range machine.InterfaceSet() {
+		if iface.Name() == name {
+			return iface, nil
+		}
+	}
+	return nil, fmt.Errorf("Machine (%s) has no interface named %s", machine.SystemID(), name)
+}
+
+// nodeConfigureInterfaces replace the links of an allocated node's interfaces with the requested configuration
+func nodeConfigureInterfaces(maas *gomaasapi.MAASObject, controller gomaasapi.Controller, system_id string, configs []NetworkInterfaceConfig) error {
+	log.Printf("[DEBUG] [nodeConfigureInterfaces] Configuring %d interface links on node (%s)", len(configs), system_id)
+
+	machine, err := controllerGetMachine(controller, system_id)
+	if err != nil {
+		return err
+	}
+
+	unlinked := map[string]bool{}
+	for _, config := range configs {
+		iface, err := machineInterface(machine, config.name)
+		if err != nil {
+			return err
+		}
+
+		// drop the links the interface had in the Ready state before adding the first requested one
+		if !unlinked[config.name] {
+			for _, link := range iface.Links() {
+				if link.Subnet() == nil {
+					continue
+				}
+				if err := iface.UnlinkSubnet(link.Subnet()); err != nil {
+					log.Printf("[ERROR] [nodeConfigureInterfaces] Unable to unlink interface (%s) on node (%s)", config.name, system_id)
+					return err
+				}
+			}
+			unlinked[config.name] = true
+		}
+
+		subnet_id := 0
+		if config.subnet != "" {
+			subnet, err := controllerFindSubnet(controller, config.subnet)
+			if err != nil {
+				return err
+			}
+			subnet_id = subnet.ID()
+		}
+
+		// gomaasapi doesn't model the AUTO link mode, nor a link_up link without a subnet
+		params := linkSubnetParams(config, subnet_id)
+		_, err = maas.GetSubObject("machines").GetSubObject(system_id).GetSubObject("interfaces").GetSubObject(strconv.Itoa(iface.ID())).CallPost("link_subnet", params)
+		if err != nil {
+			log.Printf("[ERROR] [nodeConfigureInterfaces] Unable to link interface (%s) on node (%s) to subnet (%s)", config.name, system_id, config.subnet)
+			return err
+		}
+	}
+	return nil
+}
+
+// flattenNetworkInterfaces combine the requested interface configuration with the addresses MAAS assigned
+func flattenNetworkInterfaces(machine gomaasapi.Machine, configs []NetworkInterfaceConfig) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(configs))
+	for _, config := range configs {
+		item := map[string]interface{}{
+			"name":            config.name,
+			"mode":            config.mode,
+			"subnet":          config.subnet,
+			"ip_address":      config.ip_address,
+			"default_gateway": config.default_gateway,
+		}
+
+		if iface, err := machineInterface(machine, config.name); err == nil {
+			item["mac_address"] = iface.MACAddress()
+			for _, link := range iface.Links() {
+				if link.Subnet() == nil || link.IPAddress() == "" {
+					continue
+				}
+				if link.Subnet().CIDR() == config.subnet || link.Subnet().Name() == config.subnet {
+					item["ip_address"] = link.IPAddress()
+				}
+			}
+		}
+
+		result = append(result, item)
+	}
+	return result
+}
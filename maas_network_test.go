@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestLinkSubnetParams(t *testing.T) {
+	cases := []struct {
+		config    NetworkInterfaceConfig
+		subnet_id int
+		expected  url.Values
+	}{
+		{
+			NetworkInterfaceConfig{name: "eth0", mode: linkModeAuto, subnet: "10.0.0.0/24", default_gateway: true},
+			3,
+			url.Values{"mode": {"AUTO"}, "subnet": {"3"}, "default_gateway": {"true"}},
+		},
+		{
+			NetworkInterfaceConfig{name: "eth0", mode: linkModeDHCP, subnet: "storage"},
+			4,
+			url.Values{"mode": {"DHCP"}, "subnet": {"4"}},
+		},
+		{
+			NetworkInterfaceConfig{name: "eth0", mode: linkModeStatic, subnet: "10.0.0.0/24", ip_address: "10.0.0.10", default_gateway: true},
+			3,
+			url.Values{"mode": {"STATIC"}, "subnet": {"3"}, "ip_address": {"10.0.0.10"}, "default_gateway": {"true"}},
+		},
+		{
+			NetworkInterfaceConfig{name: "eth0", mode: linkModeLinkUp, subnet: "storage"},
+			4,
+			url.Values{"mode": {"LINK_UP"}, "subnet": {"4"}},
+		},
+		{
+			NetworkInterfaceConfig{name: "eth2", mode: linkModeLinkUp},
+			0,
+			url.Values{"mode": {"LINK_UP"}},
+		},
+	}
+
+	for _, c := range cases {
+		if params := linkSubnetParams(c.config, c.subnet_id); !reflect.DeepEqual(params, c.expected) {
+			t.Fatalf("%s: got %v, expected %v", c.config.mode, params, c.expected)
+		}
+	}
+}
+
+func TestValidateNetworkInterfaceConfigs(t *testing.T) {
+	valid := []NetworkInterfaceConfig{
+		{name: "eth0", mode: linkModeStatic, subnet: "10.0.0.0/24", ip_address: "10.0.0.10", default_gateway: true},
+		{name: "eth1", mode: linkModeDHCP, subnet: "storage"},
+		{name: "eth2", mode: linkModeLinkUp},
+	}
+	if err := validateNetworkInterfaceConfigs(valid); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	invalid := []NetworkInterfaceConfig{
+		{name: "eth0", mode: linkModeAuto},
+		{name: "eth0", mode: linkModeDHCP, subnet: "storage", ip_address: "10.0.0.10"},
+		{name: "eth0", mode: linkModeDHCP, subnet: "storage", default_gateway: true},
+		{name: "eth0", mode: linkModeLinkUp, default_gateway: true},
+	}
+	for _, config := range invalid {
+		if err := validateNetworkInterfaceConfigs([]NetworkInterfaceConfig{config}); err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
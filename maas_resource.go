@@ -1,8 +1,6 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"log"
 
 	"github.com/hashicorp/terraform/helper/schema"
@@ -17,13 +15,16 @@ func resourceMAASInstance() *schema.Resource {
 		Update: resourceMAASInstanceUpdate,
 		Delete: resourceMAASInstanceDelete,
 
-		SchemaVersion: 1,
+		SchemaVersion: 8,
+		MigrateState:  resourceMAASInstanceMigrateState,
 
 		Schema: map[string]*schema.Schema{
 			"architecture": {
-				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ForceNew:         true,
+				DiffSuppressFunc: suppressArchitectureDiff,
 			},
 
 			"boot_type": {
@@ -62,62 +63,229 @@ func resourceMAASInstance() *schema.Resource {
 			},
 
 			"deploy_tags": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Optional: true,
 				ForceNew: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
+				Set:      schema.HashString,
 			},
 
 			"tags": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Optional: true,
 				ForceNew: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
+				Set:      schema.HashString,
+			},
+
+			"original_hostname": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"original_tags": {
+				Type:     schema.TypeSet,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Set:      schema.HashString,
+			},
+
+			"adopt_system_id": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"adopt_hostname"},
+			},
+
+			"adopt_hostname": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"adopt_system_id"},
 			},
 
-			"release_erase": {
+			"kernel_options": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"min_hwe_kernel": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"ephemeral_deploy": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+				Default:  false,
+			},
+
+			"install_kvm": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				ForceNew:      true,
+				Default:       false,
+				ConflictsWith: []string{"register_vmhost"},
+			},
+
+			"register_vmhost": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				ForceNew:      true,
+				Default:       false,
+				ConflictsWith: []string{"install_kvm"},
+			},
+
+			"enable_hw_sync": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+				Default:  false,
+			},
+
+			"vmhost_id": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"allocation_strategy": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      allocationMAASDefault,
+				ValidateFunc: validateStringInSlice(allocationStrategies),
+			},
+
+			"preferred_tags": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"deploy_retries": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validateIntAtLeast(0),
+			},
+
+			"deploy_retry_failed_action": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      deployFailedMarkBroken,
+				ValidateFunc: validateStringInSlice([]string{deployFailedMarkBroken, deployFailedRelease}),
+			},
+
+			"deploy_attempts": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"sticky_machine": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			"resource_address": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"release_on_destroy": {
 				Type:     schema.TypeBool,
 				Optional: true,
-				ForceNew: false,
 				Default:  true,
 			},
 
-			"release_erase_secure": {
+			"release_erase_mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     false,
+				Default:      releaseEraseFull,
+				ValidateFunc: validateStringInSlice(releaseEraseModes),
+			},
+
+			"release_comment": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"force_release": {
 				Type:     schema.TypeBool,
 				Optional: true,
 				ForceNew: false,
 				Default:  false,
 			},
 
-			"release_erase_quick": {
+			"wait_for_release": {
 				Type:     schema.TypeBool,
 				Optional: true,
 				ForceNew: false,
-				Default:  false,
+				Default:  true,
 			},
 
 			"ip_addresses": {
 				Type:     schema.TypeList,
-				Optional: true,
-				ForceNew: true,
+				Computed: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
 
+			"fqdn": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"interfaces": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac_address": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"vlan": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"fabric": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"subnets": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"ip_addresses": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
+
 			"macaddress_set": {
 				Type:     schema.TypeList,
-				Optional: true,
-				ForceNew: true,
+				Computed: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"mac_address": {
 							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
+							Computed: true,
 						},
 						"resource_uri": {
 							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
+							Computed: true,
 						},
 					},
 				},
@@ -143,51 +311,49 @@ func resourceMAASInstance() *schema.Resource {
 
 			"owner": {
 				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
+				Computed: true,
 			},
 
 			"physicalblockdevice_set": {
 				Type:     schema.TypeList,
-				Optional: true,
-				ForceNew: true,
+				Computed: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"block_size": {
 							Type:     schema.TypeInt,
-							Optional: true,
+							Computed: true,
 						},
 						"id": {
 							Type:     schema.TypeInt,
-							Optional: true,
+							Computed: true,
 						},
 						"id_path": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 						"model": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 						"name": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 						"path": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 						"serial": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 						"size": {
 							Type:     schema.TypeInt,
-							Optional: true,
+							Computed: true,
 						},
 						"tags": {
 							Type:     schema.TypeList,
-							Optional: true,
+							Computed: true,
 							Elem:     &schema.Schema{Type: schema.TypeString},
 						},
 					},
@@ -197,30 +363,65 @@ func resourceMAASInstance() *schema.Resource {
 			"power_state": {
 				Type:     schema.TypeString,
 				Optional: true,
+				Computed: true,
 			},
 
-			"power_type": {
-				Type:     schema.TypeString,
+			"locked": {
+				Type:     schema.TypeBool,
 				Optional: true,
+				Default:  false,
 			},
 
-			"pxe_mac": {
-				Type:     schema.TypeSet,
+			"rescue_mode": {
+				Type:     schema.TypeBool,
 				Optional: true,
+				Default:  false,
+			},
+
+			"run_tests": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"test_results": {
+				Type:     schema.TypeList,
+				Computed: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
-						"mac_address": {
+						"name": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
-						"resource_uri": {
+						"status": {
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 					},
 				},
 			},
 
+			"desired_power_state": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateStringInSlice([]string{powerStateOn, powerStateOff}),
+			},
+
+			"power_cycle_trigger": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"power_type": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"pxe_mac": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"resource_uri": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -255,45 +456,236 @@ func resourceMAASInstance() *schema.Resource {
 			},
 
 			"tag_names": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Optional: true,
+				Computed: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
+				Set:      schema.HashString,
 			},
 
 			"zone": {
-				Type:     schema.TypeSet,
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"pool": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"user_data": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				Sensitive:        true,
+				ConflictsWith:    []string{"user_data_base64", "cloud_init_part"},
+				StateFunc:        userDataStateFunc,
+				DiffSuppressFunc: suppressLegacyUserDataDiff,
+			},
+
+			"user_data_base64": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				Sensitive:     true,
+				ConflictsWith: []string{"user_data", "cloud_init_part"},
+				StateFunc:     userDataStateFunc,
+				ValidateFunc:  validateBase64,
+			},
+
+			"network_interface": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"mode": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							Default:      linkModeAuto,
+							ValidateFunc: validateStringInSlice(linkModes),
+						},
+						"subnet": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"ip_address": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"default_gateway": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+							Default:  false,
+						},
+						"mac_address": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			"storage_layout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ValidateFunc: validateStringInSlice(storageLayouts),
+			},
+
+			"storage_layout_options": {
+				Type:     schema.TypeList,
 				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
-						"description": {
+						"boot_size": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"root_device": {
 							Type:     schema.TypeString,
 							Optional: true,
+							ForceNew: true,
 						},
+						"root_size": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"vg_name": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"lv_name": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"lv_size": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"cache_device": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"cache_mode": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"cache_size": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"cache_no_part": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+
+			"block_device": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"storage_layout", "storage_layout_options"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
 						"name": {
 							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"filesystem": filesystemSchema(),
+						"partition": {
+							Type:     schema.TypeList,
 							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"size": {
+										Type:     schema.TypeString,
+										Required: true,
+										ForceNew: true,
+									},
+									"bootable": {
+										Type:     schema.TypeBool,
+										Optional: true,
+										ForceNew: true,
+										Default:  false,
+									},
+									"filesystem": filesystemSchema(),
+								},
+							},
 						},
-						"resource_uri": {
+					},
+				},
+			},
+
+			"cloud_init_part": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"user_data", "user_data_base64"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"content_type": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "text/cloud-config",
+						},
+						"filename": {
 							Type:     schema.TypeString,
 							Optional: true,
+							ForceNew: true,
+						},
+						"content": {
+							Type:      schema.TypeString,
+							Required:  true,
+							ForceNew:  true,
+							Sensitive: true,
+						},
+						"merge_type": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
 						},
 					},
 				},
 			},
 
-			"user_data": {
-				Type:     schema.TypeString,
+			"cloud_init_gzip": {
+				Type:     schema.TypeBool,
 				Optional: true,
 				ForceNew: true,
-				StateFunc: func(v interface{}) string {
-					switch v.(type) {
-					case string:
-						hash := sha1.Sum([]byte(v.(string)))
-						return hex.EncodeToString(hash[:])
-					default:
-						return ""
-					}
-				},
+				Default:  false,
+			},
+
+			"wait_for_cloud_init": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
 			},
 
 			"hwe_kernel": {
@@ -309,3 +701,37 @@ func resourceMAASInstance() *schema.Resource {
 		},
 	}
 }
+
+// filesystemSchema the schema of a filesystem block, shared by block devices and partitions
+func filesystemSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"fstype": {
+					Type:     schema.TypeString,
+					Required: true,
+					ForceNew: true,
+				},
+				"label": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"mount_point": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"mount_options": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// StickyMachines system_ids released by the destroy half of a sticky_machine replacement,
+// waiting to be allocated again by the create half.
+// Terraform hands the create half of a replacement an empty state, so the destroy half can't pass the machine on
+// through the state. Both halves are applied to the same resource address, which the machines are kept under.
+type StickyMachines struct {
+	sync.Mutex
+	pending map[string][]string
+}
+
+// push remember a released machine for the create with the given key
+func (s *StickyMachines) push(key string, system_id string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.pending == nil {
+		s.pending = map[string][]string{}
+	}
+	s.pending[key] = append(s.pending[key], system_id)
+}
+
+// pop take the machine released for the create with the given key, or "" if there is none
+func (s *StickyMachines) pop(key string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	ids := s.pending[key]
+	if len(ids) == 0 {
+		return ""
+	}
+	s.pending[key] = ids[1:]
+	return ids[0]
+}
+
+// stickyMachineKey the key a resource's machine is handed over under, or "" if the machine isn't sticky
+func stickyMachineKey(d *schema.ResourceData) string {
+	if !d.Get("sticky_machine").(bool) {
+		return ""
+	}
+	return d.Get("resource_address").(string)
+}
+
+// stickyAddressProvider a provider that passes the address of the maas_instance being applied on to the resource as
+// resource_address. helper/schema drops the instance info before calling the resource, and the address is the only
+// thing Terraform guarantees to be the same for both halves of a replacement and unique among the instances.
+type stickyAddressProvider struct {
+	*schema.Provider
+}
+
+// Apply implementation of terraform.ResourceProvider interface.
+func (p *stickyAddressProvider) Apply(
+	info *terraform.InstanceInfo,
+	s *terraform.InstanceState,
+	d *terraform.InstanceDiff) (*terraform.InstanceState, error) {
+	if info.Type == "maas_instance" && d != nil {
+		d = withResourceAddress(d, info.HumanId())
+	}
+	return p.Provider.Apply(info, s, d)
+}
+
+// withResourceAddress a copy of the diff that also sets resource_address
+func withResourceAddress(d *terraform.InstanceDiff, address string) *terraform.InstanceDiff {
+	d = d.DeepCopy()
+	if d.Attributes == nil {
+		d.Attributes = map[string]*terraform.ResourceAttrDiff{}
+	}
+	d.SetAttribute("resource_address", &terraform.ResourceAttrDiff{New: address})
+	return d
+}
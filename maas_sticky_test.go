@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestStickyMachines(t *testing.T) {
+	var sticky StickyMachines
+
+	if id := sticky.pop("a"); id != "" {
+		t.Fatalf("expected nothing pending, got %q", id)
+	}
+
+	sticky.push("a", "abc123")
+	sticky.push("a", "def456")
+	sticky.push("b", "ghi789")
+
+	for _, expected := range []string{"abc123", "def456", ""} {
+		if id := sticky.pop("a"); id != expected {
+			t.Fatalf("got %q, expected %q", id, expected)
+		}
+	}
+	if id := sticky.pop("b"); id != "ghi789" {
+		t.Fatalf("got %q, expected ghi789", id)
+	}
+}
+
+func TestStickyMachineKey(t *testing.T) {
+	// record the key each half of a replacement hands the machine over under
+	keys := map[string]string{}
+	provider := &stickyAddressProvider{&schema.Provider{
+		ResourcesMap: map[string]*schema.Resource{
+			"maas_instance": {
+				Schema: resourceMAASInstance().Schema,
+				Create: func(d *schema.ResourceData, meta interface{}) error {
+					keys["create "+d.Get("distro_series").(string)] = stickyMachineKey(d)
+					d.SetId("def456")
+					return nil
+				},
+				Delete: func(d *schema.ResourceData, meta interface{}) error {
+					keys["delete "+d.Id()] = stickyMachineKey(d)
+					return nil
+				},
+			},
+		},
+	}}
+
+	info := func(id string) *terraform.InstanceInfo {
+		return &terraform.InstanceInfo{Id: id, Type: "maas_instance", ModulePath: []string{"root", "storage"}}
+	}
+
+	// the destroy half of a replacement only gets the old state and a destroy diff
+	old := &terraform.InstanceState{ID: "abc123", Attributes: map[string]string{
+		"id":             "abc123",
+		"distro_series":  "xenial",
+		"sticky_machine": "true",
+	}}
+	if _, err := provider.Apply(info("maas_instance.node.0"), old, &terraform.InstanceDiff{Destroy: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// the create half only gets the new configuration
+	for id, distro_series := range map[string]string{"maas_instance.node.0": "bionic", "maas_instance.node.1": "trusty"} {
+		diff := &terraform.InstanceDiff{Attributes: map[string]*terraform.ResourceAttrDiff{
+			"distro_series":  {New: distro_series},
+			"sticky_machine": {New: "true"},
+		}}
+		if _, err := provider.Apply(info(id), nil, diff); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if key := keys["delete abc123"]; key != "module.storage.maas_instance.node.0" {
+		t.Fatalf("bad destroy key: %q", key)
+	}
+	if keys["create bionic"] != keys["delete abc123"] {
+		t.Fatalf("both halves of the replacement should use the same key: %#v", keys)
+	}
+	if keys["create trusty"] == keys["delete abc123"] {
+		t.Fatalf("another instance must not get the released machine: %#v", keys)
+	}
+
+	plain := schema.TestResourceDataRaw(t, resourceMAASInstance().Schema, map[string]interface{}{
+		"distro_series": "bionic",
+	})
+	if key := stickyMachineKey(plain); key != "" {
+		t.Fatalf("a node without sticky_machine should have no key, got %q", key)
+	}
+}
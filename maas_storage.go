@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/juju/gomaasapi"
+)
+
+// storage_layout values
+const (
+	storageLayoutFlat   = "flat"
+	storageLayoutLVM    = "lvm"
+	storageLayoutBcache = "bcache"
+	storageLayoutVMFS6  = "vmfs6"
+	storageLayoutBlank  = "blank"
+)
+
+var storageLayouts = []string{
+	storageLayoutFlat,
+	storageLayoutLVM,
+	storageLayoutBcache,
+	storageLayoutVMFS6,
+	storageLayoutBlank,
+}
+
+// storageLayoutOptions the storage_layout_options accepted by each layout
+var storageLayoutOptions = map[string][]string{
+	storageLayoutFlat:   {"boot_size", "root_device", "root_size"},
+	storageLayoutLVM:    {"boot_size", "root_device", "root_size", "vg_name", "lv_name", "lv_size"},
+	storageLayoutBcache: {"boot_size", "root_device", "root_size", "cache_device", "cache_mode", "cache_size", "cache_no_part"},
+	storageLayoutVMFS6:  {"root_device", "root_size"},
+	storageLayoutBlank:  {},
+}
+
+// storageLayoutParams build the parameters of the set_storage_layout operation,
+// rejecting options that don't apply to the chosen layout.
+func storageLayoutParams(layout string, options map[string]interface{}) (url.Values, error) {
+	allowed, ok := storageLayoutOptions[layout]
+	if !ok {
+		return nil, fmt.Errorf("Unknown storage layout: %s", layout)
+	}
+
+	params := url.Values{}
+	params.Set("storage_layout", layout)
+
+	for option, value := range options {
+		var str string
+		switch v := value.(type) {
+		case string:
+			str = v
+		case bool:
+			if v {
+				str = "true"
+			}
+		}
+		if str == "" {
+			continue
+		}
+
+		valid := false
+		for _, a := range allowed {
+			if a == option {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("storage_layout_options.%s is not supported by the %s storage layout", option, layout)
+		}
+
+		params.Set(option, str)
+	}
+	return params, nil
+}
+
+// resourceMAASInstanceStorageLayoutParams Convenience function to build the set_storage_layout parameters of a resource
+func resourceMAASInstanceStorageLayoutParams(d *schema.ResourceData) (url.Values, error) {
+	options := map[string]interface{}{}
+	if v, ok := d.GetOk("storage_layout_options"); ok && len(v.([]interface{})) > 0 && v.([]interface{})[0] != nil {
+		options = v.([]interface{})[0].(map[string]interface{})
+	}
+	return storageLayoutParams(d.Get("storage_layout").(string), options)
+}
+
+// nodeSetStorageLayout apply a preset storage layout to an allocated node
+func nodeSetStorageLayout(maas *gomaasapi.MAASObject, system_id string, params url.Values) error {
+	log.Printf("[DEBUG] [nodeSetStorageLayout] Setting storage layout of node (%s): %+v", system_id, params)
+	return nodeDo(maas, system_id, "set_storage_layout", params)
+}
+
+// FileSystemConfig the requested filesystem of a block device or partition
+type FileSystemConfig struct {
+	fstype        string
+	label         string
+	mount_point   string
+	mount_options string
+}
+
+// PartitionConfig a requested partition of a block device
+type PartitionConfig struct {
+	size       string
+	bootable   bool
+	filesystem *FileSystemConfig
+}
+
+// BlockDeviceConfig the requested partitioning of one of a machine's physical block devices
+type BlockDeviceConfig struct {
+	name       string
+	filesystem *FileSystemConfig
+	partitions []PartitionConfig
+}
+
+// toFileSystemConfig Convenience function to convert a filesystem block
+func toFileSystemConfig(v interface{}) *FileSystemConfig {
+	filesystemList, ok := v.([]interface{})
+	if !ok || len(filesystemList) == 0 || filesystemList[0] == nil {
+		return nil
+	}
+
+	filesystemMap := filesystemList[0].(map[string]interface{})
+	return &FileSystemConfig{
+		fstype:        filesystemMap["fstype"].(string),
+		label:         filesystemMap["label"].(string),
+		mount_point:   filesystemMap["mount_point"].(string),
+		mount_options: filesystemMap["mount_options"].(string),
+	}
+}
+
+// toBlockDeviceConfigs Convenience function to convert the block_device blocks of a resource
+func toBlockDeviceConfigs(d *schema.ResourceData) []BlockDeviceConfig {
+	deviceList := d.Get("block_device").([]interface{})
+
+	configs := make([]BlockDeviceConfig, 0, len(deviceList))
+	for _, v := range deviceList {
+		deviceMap := v.(map[string]interface{})
+		config := BlockDeviceConfig{
+			name:       deviceMap["name"].(string),
+			filesystem: toFileSystemConfig(deviceMap["filesystem"]),
+		}
+
+		for _, p := range deviceMap["partition"].([]interface{}) {
+			partitionMap := p.(map[string]interface{})
+			config.partitions = append(config.partitions, PartitionConfig{
+				size:       partitionMap["size"].(string),
+				bootable:   partitionMap["bootable"].(bool),
+				filesystem: toFileSystemConfig(partitionMap["filesystem"]),
+			})
+		}
+
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// parseStorageSize parse a size in bytes with an optional K, M, G or T suffix, the way MAAS does
+func parseStorageSize(size string) (uint64, error) {
+	if size == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	multiplier := uint64(1)
+	number := size
+	switch size[len(size)-1] {
+	case 'K', 'k':
+		multiplier = 1000
+	case 'M', 'm':
+		multiplier = 1000 * 1000
+	case 'G', 'g':
+		multiplier = 1000 * 1000 * 1000
+	case 'T', 't':
+		multiplier = 1000 * 1000 * 1000 * 1000
+	}
+	if multiplier != 1 {
+		number = size[:len(size)-1]
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return uint64(value * float64(multiplier)), nil
+}
+
+// MAAS starts the first partition 4 MiB into the disk, keeps some space at the end for the partition table and
+// rounds every partition up to a multiple of 4 MiB, so a disk never fits partitions adding up to its full size
+const (
+	partitionTableReserve = 8 * 1024 * 1024
+	partitionAlignment    = 4 * 1024 * 1024
+)
+
+// validateBlockDeviceConfigs make sure the requested partitions fit on the allocated node's block devices,
+// given the size in bytes of each device by name.
+func validateBlockDeviceConfigs(configs []BlockDeviceConfig, device_sizes map[string]uint64) error {
+	for _, config := range configs {
+		device_size, ok := device_sizes[config.name]
+		if !ok {
+			return fmt.Errorf("The allocated node has no block device named %s", config.name)
+		}
+
+		if config.filesystem != nil && len(config.partitions) > 0 {
+			return fmt.Errorf("Block device %s can either be formatted or partitioned, not both", config.name)
+		}
+
+		total := uint64(0)
+		for i, partition := range config.partitions {
+			size, err := parseStorageSize(partition.size)
+			if err != nil {
+				return fmt.Errorf("Partition %d of block device %s: %s", i, config.name, err)
+			}
+			total += (size + partitionAlignment - 1) / partitionAlignment * partitionAlignment
+		}
+
+		available := uint64(0)
+		if device_size > partitionTableReserve {
+			available = device_size - partitionTableReserve
+		}
+		if len(config.partitions) > 0 && total > available {
+			return fmt.Errorf("The partitions of block device %s need %d bytes but the device only has %d available", config.name, total, available)
+		}
+	}
+	return nil
+}
+
+// maasFormatAndMount This is a *low level* function that formats and optionally mounts a block device or partition
+func maasFormatAndMount(object gomaasapi.MAASObject, filesystem *FileSystemConfig) error {
+	params := url.Values{}
+	params.Set("fstype", filesystem.fstype)
+	if filesystem.label != "" {
+		params.Set("label", filesystem.label)
+	}
+	if _, err := object.CallPost("format", params); err != nil {
+		log.Printf("[ERROR] [maasFormatAndMount] Unable to format %s as %s", object.URI(), filesystem.fstype)
+		return err
+	}
+
+	if filesystem.mount_point == "" {
+		return nil
+	}
+
+	params = url.Values{}
+	params.Set("mount_point", filesystem.mount_point)
+	if filesystem.mount_options != "" {
+		params.Set("mount_options", filesystem.mount_options)
+	}
+	if _, err := object.CallPost("mount", params); err != nil {
+		log.Printf("[ERROR] [maasFormatAndMount] Unable to mount %s on %s", object.URI(), filesystem.mount_point)
+		return err
+	}
+	return nil
+}
+
+// maasCreatePartition This is a *low level* function that creates a partition on a block device and returns the partition
+func maasCreatePartition(device gomaasapi.MAASObject, partition PartitionConfig) (gomaasapi.MAASObject, error) {
+	size, err := parseStorageSize(partition.size)
+	if err != nil {
+		return gomaasapi.MAASObject{}, err
+	}
+
+	params := url.Values{}
+	params.Set("size", strconv.FormatUint(size, 10))
+	if partition.bootable {
+		params.Set("bootable", strconv.FormatBool(true))
+	}
+
+	result, err := device.GetSubObject("partitions").Post(params)
+	if err != nil {
+		log.Printf("[ERROR] [maasCreatePartition] Unable to create a %s partition on %s", partition.size, device.URI())
+		return gomaasapi.MAASObject{}, err
+	}
+
+	resultMap, err := result.GetMap()
+	if err != nil {
+		return gomaasapi.MAASObject{}, err
+	}
+	id, err := resultMap["id"].GetFloat64()
+	if err != nil {
+		return gomaasapi.MAASObject{}, err
+	}
+	return device.GetSubObject("partitions").GetSubObject(strconv.Itoa(int(id))), nil
+}
+
+// nodeConfigureBlockDevices wipe an allocated node's storage configuration and partition, format and mount
+// its block devices as requested
+func nodeConfigureBlockDevices(maas *gomaasapi.MAASObject, controller gomaasapi.Controller, system_id string, configs []BlockDeviceConfig) error {
+	log.Printf("[DEBUG] [nodeConfigureBlockDevices] Configuring %d block devices on node (%s)", len(configs), system_id)
+
+	machine, err := controllerGetMachine(controller, system_id)
+	if err != nil {
+		return err
+	}
+
+	device_ids := map[string]int{}
+	device_sizes := map[string]uint64{}
+	for _, device := range machine.PhysicalBlockDevices() {
+		device_ids[device.Name()] = device.ID()
+		device_sizes[device.Name()] = device.Size()
+	}
+
+	if err := validateBlockDeviceConfigs(configs, device_sizes); err != nil {
+		return err
+	}
+
+	// the blank layout removes every partition and filesystem from the node
+	params := url.Values{}
+	params.Set("storage_layout", storageLayoutBlank)
+	if err := nodeSetStorageLayout(maas, system_id, params); err != nil {
+		return err
+	}
+
+	devices := maas.GetSubObject("machines").GetSubObject(system_id).GetSubObject("blockdevices")
+	for _, config := range configs {
+		device := devices.GetSubObject(strconv.Itoa(device_ids[config.name]))
+
+		if config.filesystem != nil {
+			if err := maasFormatAndMount(device, config.filesystem); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, partition := range config.partitions {
+			partitionObject, err := maasCreatePartition(device, partition)
+			if err != nil {
+				return err
+			}
+			if partition.filesystem == nil {
+				continue
+			}
+			if err := maasFormatAndMount(partitionObject, partition.filesystem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStorageLayoutParams(t *testing.T) {
+	params, err := storageLayoutParams(storageLayoutLVM, map[string]interface{}{
+		"root_size":     "100G",
+		"vg_name":       "vgroot",
+		"cache_device":  "",
+		"cache_no_part": false,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if params.Get("storage_layout") != "lvm" || params.Get("root_size") != "100G" || params.Get("vg_name") != "vgroot" {
+		t.Fatalf("bad params: %+v", params)
+	}
+	if _, ok := params["cache_device"]; ok {
+		t.Fatalf("unset options should not be passed: %+v", params)
+	}
+}
+
+func TestStorageLayoutParamsUnsupportedOption(t *testing.T) {
+	if _, err := storageLayoutParams(storageLayoutFlat, map[string]interface{}{"cache_device": "sdb"}); err == nil {
+		t.Fatalf("expected an error for an option the layout doesn't support")
+	}
+	if _, err := storageLayoutParams(storageLayoutBlank, map[string]interface{}{"root_size": "10G"}); err == nil {
+		t.Fatalf("expected an error for an option the layout doesn't support")
+	}
+}
+
+func TestParseStorageSize(t *testing.T) {
+	cases := map[string]uint64{
+		"512":  512,
+		"10K":  10 * 1000,
+		"1.5G": 1500 * 1000 * 1000,
+		"2T":   2 * 1000 * 1000 * 1000 * 1000,
+	}
+	for size, expected := range cases {
+		if v, err := parseStorageSize(size); err != nil || v != expected {
+			t.Fatalf("%s: got %d (%v), expected %d", size, v, err, expected)
+		}
+	}
+
+	for _, size := range []string{"", "G", "-1G", "ten"} {
+		if _, err := parseStorageSize(size); err == nil {
+			t.Fatalf("%q: expected an error", size)
+		}
+	}
+}
+
+func TestValidateBlockDeviceConfigs(t *testing.T) {
+	devices := map[string]uint64{"sda": 100 * 1000 * 1000 * 1000}
+	ext4 := &FileSystemConfig{fstype: "ext4", mount_point: "/"}
+
+	fits := []BlockDeviceConfig{{name: "sda", partitions: []PartitionConfig{{size: "20G", filesystem: ext4}, {size: "79G"}}}}
+	if err := validateBlockDeviceConfigs(fits, devices); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	whole := []BlockDeviceConfig{{name: "sda", partitions: []PartitionConfig{{size: "20G", filesystem: ext4}, {size: "80G"}}}}
+	if err := validateBlockDeviceConfigs(whole, devices); err == nil {
+		t.Fatalf("expected an error for partitions leaving no room for the partition table")
+	}
+
+	tooBig := []BlockDeviceConfig{{name: "sda", partitions: []PartitionConfig{{size: "20G"}, {size: "81G"}}}}
+	if err := validateBlockDeviceConfigs(tooBig, devices); err == nil {
+		t.Fatalf("expected an error for partitions larger than the device")
+	}
+
+	missing := []BlockDeviceConfig{{name: "sdb", filesystem: ext4}}
+	if err := validateBlockDeviceConfigs(missing, devices); err == nil {
+		t.Fatalf("expected an error for a missing device")
+	}
+
+	both := []BlockDeviceConfig{{name: "sda", filesystem: ext4, partitions: []PartitionConfig{{size: "1G"}}}}
+	if err := validateBlockDeviceConfigs(both, devices); err == nil {
+		t.Fatalf("expected an error for a device that is both formatted and partitioned")
+	}
+}
@@ -65,3 +65,92 @@ func nodeTagsRemove(maas *gomaasapi.MAASObject, system_id string, tag_name strin
 	}
 	return nil
 }
+
+// nodeTagsRestore put a node's tags back to what they were before deployment.
+// Deploy tags the node didn't originally have are removed and any original tag that went missing is re-added.
+func nodeTagsRestore(maas *gomaasapi.MAASObject, system_id string, original_tags []string, deploy_tags []string) error {
+	log.Printf("[DEBUG] [nodeTagsRestore] Restoring node (%s) tags to %v", system_id, original_tags)
+
+	original := make(map[string]bool, len(original_tags))
+	for _, tag_name := range original_tags {
+		original[tag_name] = true
+	}
+
+	for _, tag_name := range deploy_tags {
+		if original[tag_name] {
+			continue
+		}
+		if err := nodeTagsRemove(maas, system_id, tag_name); err != nil {
+			return err
+		}
+	}
+
+	node, err := getSingleNode(maas, system_id)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(node.tag_names))
+	for _, tag_name := range node.tag_names {
+		current[tag_name] = true
+	}
+
+	for _, tag_name := range original_tags {
+		if current[tag_name] {
+			continue
+		}
+		if err := nodeTagsUpdate(maas, system_id, tag_name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kernelOptionsTagName the name of the tag carrying the kernel options of a single node
+func kernelOptionsTagName(system_id string) string {
+	return "kernel-opts-" + system_id
+}
+
+// tagSetKernelOpts create a tag with the given kernel options, or update the kernel options of an existing tag
+func tagSetKernelOpts(maas *gomaasapi.MAASObject, tag_name string, kernel_opts string) error {
+	log.Printf("[DEBUG] [tagSetKernelOpts] Setting kernel options of tag %s to %s", tag_name, kernel_opts)
+
+	params := url.Values{}
+	params.Set("kernel_opts", kernel_opts)
+
+	tagObject, err := maas.GetSubObject("tags").GetSubObject(tag_name).Get()
+	if err == nil {
+		_, err = tagObject.Update(params)
+		return err
+	}
+
+	params.Set("name", tag_name)
+	params.Set("comment", "Kernel options managed by Terraform")
+	_, err = maas.GetSubObject("tags").CallPost("", params)
+	return err
+}
+
+// tagDelete delete a tag, removing it from every node
+func tagDelete(maas *gomaasapi.MAASObject, tag_name string) error {
+	log.Printf("[DEBUG] [tagDelete] Deleting tag %s", tag_name)
+	return maas.GetSubObject("tags").GetSubObject(tag_name).Delete()
+}
+
+// nodeKernelOptionsSet attach the kernel options to a node through a tag of its own
+func nodeKernelOptionsSet(maas *gomaasapi.MAASObject, system_id string, kernel_opts string) error {
+	tag_name := kernelOptionsTagName(system_id)
+	if err := tagSetKernelOpts(maas, tag_name, kernel_opts); err != nil {
+		log.Printf("[ERROR] [nodeKernelOptionsSet] Unable to set kernel options of tag %s", tag_name)
+		return err
+	}
+	return nodeTagsUpdate(maas, system_id, tag_name)
+}
+
+// nodeKernelOptionsRemove delete the tag carrying a node's kernel options
+func nodeKernelOptionsRemove(maas *gomaasapi.MAASObject, system_id string) error {
+	err := tagDelete(maas, kernelOptionsTagName(system_id))
+	if err != nil && isNotFoundError(err) {
+		return nil
+	}
+	return err
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/plugin"
+	"github.com/hashicorp/terraform/terraform"
 )
 
 // Terraform plugin load point
 func main() {
 	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: Provider,
+		ProviderFunc: func() terraform.ResourceProvider {
+			return &stickyAddressProvider{Provider().(*schema.Provider)}
+		},
 	})
 }
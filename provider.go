@@ -31,7 +31,9 @@ func Provider() terraform.ResourceProvider {
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"maas_instance": resourceMAASInstance(),
+			"maas_instance":           resourceMAASInstance(),
+			"maas_instance_group":     resourceMAASInstanceGroup(),
+			"maas_machine_allocation": resourceMAASMachineAllocation(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -4,19 +4,108 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
-)
+	"fmt"
+	"strings"
 
-func userDataHashSum(user_data string) string {
+	"github.com/hashicorp/terraform/helper/schema"
+)
 
+// userDataBytes decode user data supplied as base64, falling back to the raw string for plain text user data
+func userDataBytes(user_data string) []byte {
 	v, err := base64.StdEncoding.DecodeString(user_data)
 	if err != nil {
 		v = []byte(user_data)
 	}
+	return v
+}
 
-	hash := sha1.Sum(v)
+// userDataHashSum the canonical hash of user data, the same whether it was supplied as plain text or base64
+func userDataHashSum(user_data string) string {
+	hash := sha1.Sum(userDataBytes(user_data))
 	return hex.EncodeToString(hash[:])
 }
 
+// userDataStateFunc store only the hash of the user data in the state
+func userDataStateFunc(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return userDataHashSum(v.(string))
+	default:
+		return ""
+	}
+}
+
+// suppressLegacyUserDataDiff ignore the difference between the canonical hash and the hash of the raw string
+// that older versions of the provider stored for base64 user data
+func suppressLegacyUserDataDiff(k, old, new string, d *schema.ResourceData) bool {
+	user_data, ok := d.Get(k).(string)
+	if !ok || user_data == "" {
+		return false
+	}
+
+	hash := sha1.Sum([]byte(user_data))
+	return old == hex.EncodeToString(hash[:]) && new == userDataHashSum(user_data)
+}
+
+// userDataEncode base64 encode user data for the deploy operation, whether it was supplied as plain text or base64
+func userDataEncode(user_data string) string {
+	return base64.StdEncoding.EncodeToString(userDataBytes(user_data))
+}
+
 func base64encode(data string) string {
 	return base64.StdEncoding.EncodeToString([]byte(data))
 }
+
+// validateBase64 make sure a string attribute is valid base64
+func validateBase64(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := base64.StdEncoding.DecodeString(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%s is not valid base64: %s", k, err))
+	}
+	return
+}
+
+// validateIntAtLeast returns a SchemaValidateFunc which tests if the provided value
+// is of type int and at least min
+func validateIntAtLeast(min int) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, errors []error) {
+		value, ok := v.(int)
+		if !ok {
+			errors = append(errors, fmt.Errorf("expected type of %s to be int", k))
+			return
+		}
+
+		if value < min {
+			errors = append(errors, fmt.Errorf("expected %s to be at least %d, got %d", k, min, value))
+		}
+		return
+	}
+}
+
+// validateStringInSlice returns a ValidateFunc that makes sure a string attribute is one of the valid values
+func validateStringInSlice(valid []string) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, errors []error) {
+		value, ok := v.(string)
+		if !ok {
+			errors = append(errors, fmt.Errorf("expected type of %s to be string", k))
+			return
+		}
+
+		for _, str := range valid {
+			if value == str {
+				return
+			}
+		}
+
+		errors = append(errors, fmt.Errorf("expected %s to be one of %v, got %s", k, valid, value))
+		return
+	}
+}
+
+// suppressArchitectureDiff treat an architecture without a subarchitecture (amd64) as matching the full
+// architecture MAAS reports for the node (amd64/generic)
+func suppressArchitectureDiff(k, old, new string, d *schema.ResourceData) bool {
+	if new == "" || old == new {
+		return true
+	}
+	return strings.HasPrefix(old, new+"/")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUserDataHashSum(t *testing.T) {
+	plain := "#cloud-config\npackages:\n  - ntp\n"
+	encoded := base64encode(plain)
+
+	if userDataHashSum(plain) != userDataHashSum(encoded) {
+		t.Fatalf("plain and base64 user data should hash the same")
+	}
+	if userDataStateFunc(plain) != userDataHashSum(plain) {
+		t.Fatalf("the state function should use the canonical hash")
+	}
+	if userDataEncode(plain) != encoded || userDataEncode(encoded) != encoded {
+		t.Fatalf("plain and base64 user data should encode the same")
+	}
+}
+
+func TestValidateBase64(t *testing.T) {
+	if _, errs := validateBase64(base64encode("#!/bin/bash"), "user_data_base64"); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if _, errs := validateBase64("#!/bin/bash", "user_data_base64"); len(errs) == 0 {
+		t.Fatalf("expected an error for invalid base64")
+	}
+}
+
+func TestSuppressArchitectureDiff(t *testing.T) {
+	cases := []struct {
+		Old, New string
+		Suppress bool
+	}{
+		{"amd64/generic", "amd64/generic", true},
+		{"amd64/generic", "amd64", true},
+		{"amd64/generic", "", true},
+		{"amd64/generic", "arm64", false},
+		{"amd64/generic", "amd64/hwe-16.04", false},
+	}
+
+	for _, tc := range cases {
+		if v := suppressArchitectureDiff("architecture", tc.Old, tc.New, nil); v != tc.Suppress {
+			t.Fatalf("%q -> %q: got %t, expected %t", tc.Old, tc.New, v, tc.Suppress)
+		}
+	}
+}
+
+func TestValidateIntAtLeast(t *testing.T) {
+	validate := validateIntAtLeast(0)
+
+	for _, v := range []int{0, 3} {
+		if _, errs := validate(v, "deploy_retries"); len(errs) != 0 {
+			t.Fatalf("%d should be valid: %v", v, errs)
+		}
+	}
+	if _, errs := validate(-2, "deploy_retries"); len(errs) == 0 {
+		t.Fatalf("-2 should be rejected")
+	}
+}